@@ -0,0 +1,66 @@
+package sshserver
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ForwardedAgent is a connection to the SSH agent of the client behind a
+// session. Its signers can authenticate to other hosts on the user's behalf,
+// e.g. with ssh.PublicKeysCallback(a.Signers).
+type ForwardedAgent struct {
+	agent.ExtendedAgent
+	channel ssh.Channel
+}
+
+// Close closes the connection to the agent
+func (a *ForwardedAgent) Close() error {
+	return a.channel.Close()
+}
+
+// AgentForwarded reports whether the client forwarded its SSH agent
+// ("ssh -A") and the server accepted it
+func (s *Session) AgentForwarded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.agentForwarded
+}
+
+// Agent opens a connection to the client's forwarded SSH agent. It fails
+// unless AgentForwarded is true. Close the agent when it is no longer needed.
+func (s *Session) Agent() (*ForwardedAgent, error) {
+	if !s.AgentForwarded() {
+		return nil, fmt.Errorf("agent forwarding is not enabled for this session")
+	}
+
+	channel, requests, err := s.conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open agent channel: %v", err)
+	}
+	go ssh.DiscardRequests(requests)
+
+	return &ForwardedAgent{
+		ExtendedAgent: agent.NewClient(channel),
+		channel:       channel,
+	}, nil
+}
+
+// setAgentForwarded records that the client forwarded its agent
+func (s *Session) setAgentForwarded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.agentForwarded = true
+}
+
+// agentForwardingAllowed reports whether the user behind info may forward
+// their agent
+func (c *Config) agentForwardingAllowed(info SessionInfo) bool {
+	if !c.AllowAgentForwarding {
+		return false
+	}
+	return c.AgentForwardingPolicy == nil || c.AgentForwardingPolicy(info)
+}
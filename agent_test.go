@@ -0,0 +1,97 @@
+package sshserver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"log"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentForwarding(t *testing.T) {
+	s := &Server{
+		config: &Config{AllowAgentForwarding: true},
+		logger: log.New(&bytes.Buffer{}, "", 0),
+	}
+	client, sessions := newTestClient(t, s, nil)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: "alice@laptop"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.ForwardToAgent(client, keyring); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	sess := <-sessions
+
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		t.Fatalf("agent forwarding refused: %v", err)
+	}
+	if !sess.AgentForwarded() {
+		t.Fatal("AgentForwarded is false after an accepted request")
+	}
+
+	forwarded, err := sess.Agent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forwarded.Close()
+	keys, err := forwarded.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Comment != "alice@laptop" {
+		t.Errorf("got keys %v from the forwarded agent", keys)
+	}
+}
+
+func TestAgentForwardingRefused(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		perms  *ssh.Permissions
+	}{
+		{"disabled", &Config{}, nil},
+		{"policy", &Config{
+			AllowAgentForwarding:  true,
+			AgentForwardingPolicy: func(info SessionInfo) bool { return info.User != "alice" },
+		}, nil},
+		{"restricted key", &Config{AllowAgentForwarding: true}, &ssh.Permissions{
+			Extensions: map[string]string{restrictExtension: ""},
+		}},
+	}
+	for _, tt := range tests {
+		s := &Server{config: tt.config, logger: log.New(&bytes.Buffer{}, "", 0)}
+		client, sessions := newTestClient(t, s, tt.perms)
+
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sess := <-sessions
+
+		if err := agent.RequestAgentForwarding(session); err == nil {
+			t.Errorf("%s: agent forwarding accepted", tt.name)
+		}
+		if sess.AgentForwarded() {
+			t.Errorf("%s: AgentForwarded is true after a refused request", tt.name)
+		}
+		if _, err := sess.Agent(); err == nil {
+			t.Errorf("%s: Agent succeeded without forwarding", tt.name)
+		}
+		session.Close()
+	}
+}
@@ -0,0 +1,237 @@
+package sshserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// authMethodNames are the methods that can appear in Config.AuthenticationMethods
+var authMethodNames = map[string]bool{
+	"publickey":            true,
+	"password":             true,
+	"keyboard-interactive": true,
+}
+
+// parseAuthChains parses an AuthenticationMethods value such as
+// "publickey,keyboard-interactive publickey,password"
+func parseAuthChains(value string) ([][]string, error) {
+	var chains [][]string
+	for _, field := range strings.Fields(value) {
+		chain := strings.Split(field, ",")
+		for _, method := range chain {
+			if !authMethodNames[method] {
+				return nil, fmt.Errorf("unknown authentication method %q", method)
+			}
+		}
+		chains = append(chains, chain)
+	}
+
+	if len(chains) == 0 {
+		return nil, fmt.Errorf("no authentication methods listed")
+	}
+	return chains, nil
+}
+
+// authChains returns the method chains user must complete, or nil when any
+// single method is enough. Groups come from Config.Groups and from the user's
+// account, if any.
+func (c *Config) authChains(user string, account *Account) [][]string {
+	if len(c.AuthenticationMethods) == 0 {
+		return nil
+	}
+
+	value, ok := c.AuthenticationMethods[user]
+	if !ok {
+		var groups []string
+		for group, members := range c.Groups {
+			if containsString(members, user) {
+				groups = append(groups, group)
+			}
+		}
+		if account != nil {
+			groups = append(groups, account.Groups...)
+		}
+		sort.Strings(groups)
+
+		for _, group := range groups {
+			if v, found := c.AuthenticationMethods["@"+group]; found {
+				value, ok = v, true
+				break
+			}
+		}
+	}
+	if !ok {
+		value, ok = c.AuthenticationMethods["*"]
+	}
+	if !ok {
+		return nil
+	}
+
+	// Validate has already checked the syntax
+	chains, _ := parseAuthChains(value)
+	return chains
+}
+
+// baseAuthCallbacks returns the callbacks of every enabled authentication
+// method, consulting the Authenticator first if one is set
+func (s *Server) baseAuthCallbacks() ssh.ServerAuthCallbacks {
+	var cb ssh.ServerAuthCallbacks
+	if s.keyStore != nil || s.config.TrustedUserCAKeysFile != "" {
+		cb.PublicKeyCallback = s.validatePublicKey
+	}
+	if s.passwordAuth != nil {
+		cb.PasswordCallback = s.validatePassword
+	}
+	if s.challengeFlow != nil || s.config.AllowKeyboardInteractive {
+		cb.KeyboardInteractiveCallback = s.handleKeyboardInteractive
+	}
+	if s.authenticator != nil {
+		cb = s.externalAuthCallbacks(cb)
+	}
+	return cb
+}
+
+// updateAuthCallbacks installs the callbacks of the enabled authentication
+// methods, enforcing Config.AuthenticationMethods
+func (s *Server) updateAuthCallbacks() {
+	if s.config.NoClientAuth {
+		return
+	}
+
+	cb := s.authCallbacks(s.baseAuthCallbacks(), nil, nil)
+	s.sshConfig.PublicKeyCallback = cb.PublicKeyCallback
+	s.sshConfig.PasswordCallback = cb.PasswordCallback
+	s.sshConfig.KeyboardInteractiveCallback = cb.KeyboardInteractiveCallback
+}
+
+// authCallbacks wraps the base callbacks so each step of a method chain is
+// enforced. chains holds what remains of the user's chains after the methods
+// passed so far, or nil before the first step; perms accumulates the
+// permissions granted by those methods.
+func (s *Server) authCallbacks(base ssh.ServerAuthCallbacks, chains [][]string, perms *ssh.Permissions) ssh.ServerAuthCallbacks {
+	var cb ssh.ServerAuthCallbacks
+
+	if base.PublicKeyCallback != nil && chainsAllow(chains, "publickey") {
+		cb.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return s.authStep(conn, base, chains, perms, "publickey", func() (*ssh.Permissions, error) {
+				return base.PublicKeyCallback(conn, key)
+			})
+		}
+	}
+
+	if base.PasswordCallback != nil && chainsAllow(chains, "password") {
+		cb.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return s.authStep(conn, base, chains, perms, "password", func() (*ssh.Permissions, error) {
+				return base.PasswordCallback(conn, password)
+			})
+		}
+	}
+
+	if base.KeyboardInteractiveCallback != nil && chainsAllow(chains, "keyboard-interactive") {
+		cb.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			return s.authStep(conn, base, chains, perms, "keyboard-interactive", func() (*ssh.Permissions, error) {
+				return base.KeyboardInteractiveCallback(conn, client)
+			})
+		}
+	}
+
+	return cb
+}
+
+// authStep runs authenticate for method and decides whether the user is done
+// or must continue with another method
+func (s *Server) authStep(conn ssh.ConnMetadata, base ssh.ServerAuthCallbacks, chains [][]string, perms *ssh.Permissions, method string, authenticate func() (*ssh.Permissions, error)) (*ssh.Permissions, error) {
+	account, err := s.lookupAccount(conn.User())
+	if err != nil {
+		return nil, err
+	}
+
+	if chains == nil {
+		if chains = s.config.authChains(conn.User(), account); chains == nil {
+			perms, err := authenticate()
+			if err != nil {
+				return nil, err
+			}
+			return withAccount(perms, account), nil
+		}
+	}
+
+	var next [][]string
+	for _, chain := range chains {
+		if chain[0] == method {
+			next = append(next, chain[1:])
+		}
+	}
+	if len(next) == 0 {
+		return nil, fmt.Errorf("%s authentication is not allowed for %q at this step", method, conn.User())
+	}
+
+	granted, err := authenticate()
+	if err != nil {
+		return nil, err
+	}
+	perms = mergePermissions(perms, granted, method)
+
+	for _, rest := range next {
+		if len(rest) == 0 {
+			return withAccount(perms, account), nil
+		}
+	}
+
+	s.logger.Printf("User %s passed %s authentication, further methods required", conn.User(), method)
+	return nil, &ssh.PartialSuccessError{Next: s.authCallbacks(base, next, perms)}
+}
+
+// chainsAllow reports whether method may be used next. Any enabled method may
+// start authentication; whether it is allowed for the user is checked once the
+// user is known.
+func chainsAllow(chains [][]string, method string) bool {
+	if chains == nil {
+		return true
+	}
+	for _, chain := range chains {
+		if chain[0] == method {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePermissions combines the permissions granted by the methods of a chain
+// and records the methods passed in the "auth-methods" extension
+func mergePermissions(perms, granted *ssh.Permissions, method string) *ssh.Permissions {
+	merged := &ssh.Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      make(map[string]string),
+	}
+	for _, p := range []*ssh.Permissions{perms, granted} {
+		if p == nil {
+			continue
+		}
+		for k, v := range p.CriticalOptions {
+			merged.CriticalOptions[k] = v
+		}
+		for k, v := range p.Extensions {
+			merged.Extensions[k] = v
+		}
+	}
+
+	if methods := merged.Extensions["auth-methods"]; methods != "" {
+		merged.Extensions["auth-methods"] = methods + "," + method
+	} else {
+		merged.Extensions["auth-methods"] = method
+	}
+	return merged
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package sshserver
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseAuthChains(t *testing.T) {
+	tests := []struct {
+		value string
+		want  [][]string
+	}{
+		{"publickey", [][]string{{"publickey"}}},
+		{"publickey,keyboard-interactive publickey,password", [][]string{
+			{"publickey", "keyboard-interactive"},
+			{"publickey", "password"},
+		}},
+		{"  password\tpublickey,password,keyboard-interactive ", [][]string{
+			{"password"},
+			{"publickey", "password", "keyboard-interactive"},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := parseAuthChains(tt.value)
+		if err != nil {
+			t.Errorf("parseAuthChains(%q): %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAuthChains(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "  ", "hostbased", "publickey,,password", "publickey,", "publickey password,gssapi"} {
+		if _, err := parseAuthChains(value); err == nil {
+			t.Errorf("parseAuthChains(%q) succeeded", value)
+		}
+	}
+}
+
+func TestConfigAuthChains(t *testing.T) {
+	c := &Config{
+		AuthenticationMethods: map[string]string{
+			"root":    "publickey,password",
+			"@admins": "publickey,keyboard-interactive",
+			"@ops":    "password,keyboard-interactive",
+			"*":       "publickey",
+		},
+		Groups: map[string][]string{
+			"ops": {"alice"},
+		},
+	}
+
+	tests := []struct {
+		user    string
+		account *Account
+		want    [][]string
+	}{
+		{"root", &Account{Name: "root", Groups: []string{"admins"}}, [][]string{{"publickey", "password"}}},
+		// Groups are tried in sorted order, whether from Config.Groups or
+		// from the account
+		{"alice", &Account{Name: "alice", Groups: []string{"admins"}}, [][]string{{"publickey", "keyboard-interactive"}}},
+		{"alice", nil, [][]string{{"password", "keyboard-interactive"}}},
+		{"bob", nil, [][]string{{"publickey"}}},
+	}
+	for _, tt := range tests {
+		if got := c.authChains(tt.user, tt.account); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("authChains(%q, %+v) = %q, want %q", tt.user, tt.account, got, tt.want)
+		}
+	}
+
+	delete(c.AuthenticationMethods, "*")
+	if got := c.authChains("bob", nil); got != nil {
+		t.Errorf("got %q for a user without a rule", got)
+	}
+	if got := (&Config{}).authChains("bob", nil); got != nil {
+		t.Errorf("got %q without AuthenticationMethods", got)
+	}
+}
+
+func TestChainsAllow(t *testing.T) {
+	chains := [][]string{{"publickey", "password"}, {"keyboard-interactive"}}
+	for method, want := range map[string]bool{
+		"publickey":            true,
+		"keyboard-interactive": true,
+		"password":             false,
+	} {
+		if got := chainsAllow(chains, method); got != want {
+			t.Errorf("chainsAllow(%q) = %v, want %v", method, got, want)
+		}
+	}
+	if !chainsAllow(nil, "password") {
+		t.Error("method refused before the user is known")
+	}
+}
+
+func TestMergePermissions(t *testing.T) {
+	first := mergePermissions(nil, &ssh.Permissions{
+		Extensions: map[string]string{"pubkey-fp": "SHA256:abc"},
+	}, "publickey")
+	merged := mergePermissions(first, &ssh.Permissions{
+		CriticalOptions: map[string]string{"force-command": "uptime"},
+	}, "password")
+
+	want := &ssh.Permissions{
+		CriticalOptions: map[string]string{"force-command": "uptime"},
+		Extensions: map[string]string{
+			"pubkey-fp":    "SHA256:abc",
+			"auth-methods": "publickey,password",
+		},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("got %+v, want %+v", merged, want)
+	}
+	if first.Extensions["auth-methods"] != "publickey" {
+		t.Errorf("merging modified the earlier permissions")
+	}
+}
@@ -0,0 +1,247 @@
+package sshserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// attributeExtensionPrefix prefixes the extensions holding the attributes an
+// Authenticator returned
+const attributeExtensionPrefix = "attr-"
+
+var (
+	// ErrUnsupportedMethod is returned by an Authenticator that does not
+	// handle an authentication method. The server then falls back to its
+	// built-in authentication for that method, if enabled.
+	ErrUnsupportedMethod = errors.New("authentication method not supported")
+
+	// ErrAccessDenied is returned by an Authenticator when the backend
+	// rejected the credentials, as opposed to failing to answer
+	ErrAccessDenied = errors.New("access denied")
+)
+
+// AuthResult describes a user accepted by an Authenticator
+type AuthResult struct {
+	// Groups and Roles become the user's Account when the server has no
+	// UserDirectory
+	Groups []string
+	Roles  []string
+
+	// Attributes are extra values from the backend (e.g. a display name),
+	// available to handlers as SessionInfo.Attributes
+	Attributes map[string]string
+}
+
+// Authenticator checks credentials against an external backend such as a
+// directory service. Each method returns ErrAccessDenied when the backend
+// rejects the user and ErrUnsupportedMethod when it does not handle the
+// method.
+type Authenticator interface {
+	// PublicKey authenticates a client offering key
+	PublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*AuthResult, error)
+	// Password authenticates a client using password
+	Password(conn ssh.ConnMetadata, password []byte) (*AuthResult, error)
+	// KeyboardInteractive authenticates a client by asking it questions
+	KeyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*AuthResult, error)
+}
+
+// SetAuthenticator makes the server check credentials with auth before its
+// built-in methods, which are only used for methods auth does not support. A
+// nil auth restores the built-in methods alone.
+func (s *Server) SetAuthenticator(auth Authenticator) {
+	s.authenticator = auth
+	s.updateAuthCallbacks()
+}
+
+// externalAuthCallbacks returns callbacks asking the server's Authenticator
+// first and falling back to the callbacks in builtin
+func (s *Server) externalAuthCallbacks(builtin ssh.ServerAuthCallbacks) ssh.ServerAuthCallbacks {
+	return ssh.ServerAuthCallbacks{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if err := s.checkRevoked(conn, key); err != nil {
+				return nil, err
+			}
+			// builtin.PublicKeyCallback is validatePublicKey; the key has
+			// already been checked against the revocation list
+			result, err := s.authenticator.PublicKey(conn, key)
+			perms, err := s.externalResult(conn, "publickey", result, err, func() (*ssh.Permissions, error) {
+				return s.validateUnrevokedKey(conn, key)
+			}, builtin.PublicKeyCallback != nil)
+			if err == nil && result != nil {
+				perms.Extensions["pubkey-fp"] = ssh.FingerprintSHA256(key)
+			}
+			return perms, err
+		},
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			result, err := s.authenticator.Password(conn, password)
+			return s.externalResult(conn, "password", result, err, func() (*ssh.Permissions, error) {
+				return builtin.PasswordCallback(conn, password)
+			}, builtin.PasswordCallback != nil)
+		},
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			result, err := s.authenticator.KeyboardInteractive(conn, client)
+			return s.externalResult(conn, "keyboard-interactive", result, err, func() (*ssh.Permissions, error) {
+				return builtin.KeyboardInteractiveCallback(conn, client)
+			}, builtin.KeyboardInteractiveCallback != nil)
+		},
+	}
+}
+
+// externalResult turns the outcome of an Authenticator call into
+// permissions, running fallback when the method is unsupported
+func (s *Server) externalResult(conn ssh.ConnMetadata, method string, result *AuthResult, err error, fallback func() (*ssh.Permissions, error), hasFallback bool) (*ssh.Permissions, error) {
+	switch {
+	case err == ErrUnsupportedMethod && hasFallback:
+		return fallback()
+	case err == ErrUnsupportedMethod:
+		return nil, fmt.Errorf("%s authentication not supported", method)
+	case err == ErrAccessDenied:
+		s.logger.Printf("External %s authentication denied for user %s", method, conn.User())
+		return nil, fmt.Errorf("%s authentication failed for %q", method, conn.User())
+	case err != nil:
+		s.logger.Printf("External %s authentication failed for user %s: %v", method, conn.User(), err)
+		return nil, fmt.Errorf("%s authentication failed for %q", method, conn.User())
+	case result == nil:
+		return nil, fmt.Errorf("%s authentication failed for %q", method, conn.User())
+	}
+
+	s.logger.Printf("External %s authentication successful for user: %s", method, conn.User())
+	return result.permissions(conn.User()), nil
+}
+
+// permissions records the result in ssh.Permissions, as the account of user
+// and attr-* extensions
+func (r *AuthResult) permissions(user string) *ssh.Permissions {
+	perms := &ssh.Permissions{Extensions: make(map[string]string)}
+	for name, value := range r.Attributes {
+		perms.Extensions[attributeExtensionPrefix+name] = value
+	}
+	if len(r.Groups) > 0 || len(r.Roles) > 0 {
+		perms = withAccount(perms, &Account{Name: user, Groups: r.Groups, Roles: r.Roles})
+	}
+	return perms
+}
+
+// attributesFromPermissions returns the attributes recorded by
+// AuthResult.permissions, or nil
+func attributesFromPermissions(perms *ssh.Permissions) map[string]string {
+	var attrs map[string]string
+	for k, v := range perms.Extensions {
+		if name, ok := strings.CutPrefix(k, attributeExtensionPrefix); ok {
+			if attrs == nil {
+				attrs = make(map[string]string)
+			}
+			attrs[name] = v
+		}
+	}
+	return attrs
+}
+
+// CachingAuthenticator remembers the answers of another Authenticator for a
+// TTL, so repeated logins do not reach the backend. Answers are kept per user,
+// credential and client host, since backends may decide by address.
+// Acceptances and denials are cached; errors such as an unreachable backend
+// are not. Passwords are
+// only kept as keyed hashes. Keyboard-interactive authentication is never
+// cached since its answers change between attempts.
+type CachingAuthenticator struct {
+	auth   Authenticator
+	ttl    time.Duration
+	secret []byte
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	result  *AuthResult
+	err     error
+	expires time.Time
+}
+
+// NewCachingAuthenticator wraps auth so its answers are cached for ttl
+func NewCachingAuthenticator(auth Authenticator, ttl time.Duration) *CachingAuthenticator {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+
+	return &CachingAuthenticator{
+		auth:    auth,
+		ttl:     ttl,
+		secret:  secret,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// PublicKey implements Authenticator
+func (c *CachingAuthenticator) PublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*AuthResult, error) {
+	id := "publickey\x00" + conn.User() + "\x00" + remoteHost(conn) + "\x00" + string(key.Marshal())
+	return c.cached(id, func() (*AuthResult, error) {
+		return c.auth.PublicKey(conn, key)
+	})
+}
+
+// Password implements Authenticator
+func (c *CachingAuthenticator) Password(conn ssh.ConnMetadata, password []byte) (*AuthResult, error) {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(password)
+	id := "password\x00" + conn.User() + "\x00" + remoteHost(conn) + "\x00" + string(mac.Sum(nil))
+	return c.cached(id, func() (*AuthResult, error) {
+		return c.auth.Password(conn, password)
+	})
+}
+
+// KeyboardInteractive implements Authenticator without caching
+func (c *CachingAuthenticator) KeyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*AuthResult, error) {
+	return c.auth.KeyboardInteractive(conn, client)
+}
+
+// Flush forgets every cached answer, e.g. after permissions changed in the
+// backend
+func (c *CachingAuthenticator) Flush() {
+	c.mu.Lock()
+	c.entries = make(map[string]cacheEntry)
+	c.mu.Unlock()
+}
+
+// remoteHost returns the client host of conn. Cached answers are reused
+// across connections from the same host but never for another one.
+func remoteHost(conn ssh.ConnMetadata) string {
+	host, _ := splitHostPort(conn.RemoteAddr())
+	return host
+}
+
+// cached returns the answer stored under id, calling authenticate when there
+// is none or it has expired
+func (c *CachingAuthenticator) cached(id string, authenticate func() (*AuthResult, error)) (*AuthResult, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[id]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.result, entry.err
+	}
+
+	result, err := authenticate()
+	if err != nil && err != ErrAccessDenied && err != ErrUnsupportedMethod {
+		return result, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[id] = cacheEntry{result: result, err: err, expires: now.Add(c.ttl)}
+	return result, err
+}
@@ -0,0 +1,182 @@
+package sshserver
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testConn is the ssh.ConnMetadata of a client logging in as user
+type testConn struct {
+	user string
+}
+
+func (c testConn) User() string          { return c.user }
+func (c testConn) SessionID() []byte     { return nil }
+func (c testConn) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+func (c testConn) ServerVersion() []byte { return []byte("SSH-2.0-test") }
+func (c testConn) RemoteAddr() net.Addr  { return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000} }
+func (c testConn) LocalAddr() net.Addr   { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22} }
+
+// addrConn is a testConn from another client address
+type addrConn struct {
+	testConn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// countingAuthenticator answers every call with err, accepting when it is nil,
+// and counts the calls
+type countingAuthenticator struct {
+	err   error
+	calls int
+}
+
+func (a *countingAuthenticator) answer() (*AuthResult, error) {
+	a.calls++
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &AuthResult{Roles: []string{"admin"}}, nil
+}
+
+func (a *countingAuthenticator) PublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*AuthResult, error) {
+	return a.answer()
+}
+
+func (a *countingAuthenticator) Password(conn ssh.ConnMetadata, password []byte) (*AuthResult, error) {
+	return a.answer()
+}
+
+func (a *countingAuthenticator) KeyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*AuthResult, error) {
+	return a.answer()
+}
+
+func TestCachingAuthenticatorCachesAnswers(t *testing.T) {
+	for _, answer := range []error{nil, ErrAccessDenied, ErrUnsupportedMethod} {
+		backend := &countingAuthenticator{err: answer}
+		cache := NewCachingAuthenticator(backend, time.Minute)
+
+		for i := 0; i < 3; i++ {
+			result, err := cache.Password(testConn{"alice"}, []byte("secret"))
+			if err != answer || (answer == nil) != (result != nil) {
+				t.Fatalf("got %v, %v for backend answer %v", result, err, answer)
+			}
+		}
+		if backend.calls != 1 {
+			t.Errorf("answer %v: backend called %d times, want 1", answer, backend.calls)
+		}
+	}
+}
+
+func TestCachingAuthenticatorSkipsErrors(t *testing.T) {
+	backend := &countingAuthenticator{err: errors.New("connection refused")}
+	cache := NewCachingAuthenticator(backend, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Password(testConn{"alice"}, []byte("secret")); err != backend.err {
+			t.Fatalf("got %v", err)
+		}
+	}
+	if backend.calls != 3 {
+		t.Errorf("backend called %d times, want 3", backend.calls)
+	}
+
+	// Once the backend is back its answer is used
+	backend.err = nil
+	if _, err := cache.Password(testConn{"alice"}, []byte("secret")); err != nil {
+		t.Errorf("got %v after the backend recovered", err)
+	}
+}
+
+func TestCachingAuthenticatorExpiry(t *testing.T) {
+	backend := &countingAuthenticator{err: ErrAccessDenied}
+	cache := NewCachingAuthenticator(backend, 20*time.Millisecond)
+
+	cache.Password(testConn{"alice"}, []byte("secret"))
+	backend.err = nil
+	if _, err := cache.Password(testConn{"alice"}, []byte("secret")); err != ErrAccessDenied {
+		t.Fatalf("got %v before expiry, want the cached denial", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := cache.Password(testConn{"alice"}, []byte("secret")); err != nil {
+		t.Errorf("got %v after expiry", err)
+	}
+	if backend.calls != 2 {
+		t.Errorf("backend called %d times, want 2", backend.calls)
+	}
+
+	cache.Flush()
+	cache.Password(testConn{"alice"}, []byte("secret"))
+	if backend.calls != 3 {
+		t.Errorf("Flush kept the cached answer")
+	}
+}
+
+func TestCachingAuthenticatorKeys(t *testing.T) {
+	backend := &countingAuthenticator{}
+	cache := NewCachingAuthenticator(backend, time.Minute)
+	key := newTestPublicKey(t)
+
+	cache.Password(testConn{"alice"}, []byte("secret"))
+	cache.Password(testConn{"alice"}, []byte("other"))
+	cache.Password(testConn{"bob"}, []byte("secret"))
+	cache.PublicKey(testConn{"alice"}, key)
+	cache.PublicKey(testConn{"alice"}, newTestPublicKey(t))
+	cache.PublicKey(testConn{"alice"}, key)
+	if backend.calls != 5 {
+		t.Errorf("backend called %d times, want 5", backend.calls)
+	}
+
+	for id := range cache.entries {
+		if strings.Contains(id, "secret") {
+			t.Errorf("cache key %q holds the password", id)
+		}
+	}
+
+	// Keyboard-interactive answers are never reused
+	cache.KeyboardInteractive(testConn{"alice"}, nil)
+	cache.KeyboardInteractive(testConn{"alice"}, nil)
+	if backend.calls != 7 {
+		t.Errorf("keyboard-interactive answered from the cache")
+	}
+}
+
+func TestCachingAuthenticatorPerHost(t *testing.T) {
+	backend := &countingAuthenticator{err: ErrAccessDenied}
+	cache := NewCachingAuthenticator(backend, time.Minute)
+	key := newTestPublicKey(t)
+
+	office := addrConn{testConn{"alice"}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}}
+	officeAgain := addrConn{testConn{"alice"}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50001}}
+	home := addrConn{testConn{"alice"}, &net.TCPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 50000}}
+
+	// Denied from the office, a backend deciding by address lets alice in
+	// from home
+	if _, err := cache.Password(office, []byte("secret")); err != ErrAccessDenied {
+		t.Fatalf("got %v", err)
+	}
+	backend.err = nil
+	if _, err := cache.Password(home, []byte("secret")); err != nil {
+		t.Errorf("answer for another host reused: %v", err)
+	}
+	if _, err := cache.PublicKey(home, key); err != nil {
+		t.Fatal(err)
+	}
+	backend.err = ErrAccessDenied
+	if _, err := cache.PublicKey(office, key); err != ErrAccessDenied {
+		t.Errorf("key accepted from home reused for the office: %v", err)
+	}
+
+	// A new connection from the same host, on another port, shares entries
+	calls := backend.calls
+	if _, err := cache.Password(officeAgain, []byte("secret")); err != ErrAccessDenied || backend.calls != calls {
+		t.Errorf("same host not answered from the cache: %v, %d calls", err, backend.calls-calls)
+	}
+}
@@ -0,0 +1,56 @@
+// Package authtest provides in-process fake backends for the external
+// authenticators of sshserver, so servers using them can be tested without a
+// real directory service, in the spirit of net/http/httptest.
+package authtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+
+	sshserver "repo.nusatek.id/sugeng/gosh"
+)
+
+// WebhookServer is a fake endpoint for sshserver.WebhookAuthenticator
+type WebhookServer struct {
+	// URL is the endpoint to set as WebhookAuthenticator.URL
+	URL string
+
+	server   *httptest.Server
+	requests atomic.Int64
+}
+
+// NewWebhookServer starts a webhook endpoint answering every request with the
+// response returned by decide
+func NewWebhookServer(decide func(sshserver.WebhookRequest) sshserver.WebhookResponse) *WebhookServer {
+	w := &WebhookServer{}
+	w.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.requests.Add(1)
+
+		var req sshserver.WebhookRequest
+		if r.Method != http.MethodPost {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(decide(req))
+	}))
+	w.URL = w.server.URL
+	return w
+}
+
+// Requests returns the number of requests received so far
+func (w *WebhookServer) Requests() int {
+	return int(w.requests.Load())
+}
+
+// Close shuts the endpoint down
+func (w *WebhookServer) Close() {
+	w.server.Close()
+}
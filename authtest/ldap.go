@@ -0,0 +1,238 @@
+package authtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"repo.nusatek.id/sugeng/gosh/internal/ber"
+)
+
+// LDAP protocol operations and result codes understood by LDAPServer
+const (
+	bindRequest       = ber.ClassApplication | ber.Constructed | 0
+	bindResponse      = ber.ClassApplication | ber.Constructed | 1
+	unbindRequest     = ber.ClassApplication | 2
+	searchRequest     = ber.ClassApplication | ber.Constructed | 3
+	searchResultEntry = ber.ClassApplication | ber.Constructed | 4
+	searchResultDone  = ber.ClassApplication | ber.Constructed | 5
+
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultNoSuchObject       = 32
+	resultInvalidCredentials = 49
+	resultInsufficientAccess = 50
+	resultUnwillingToPerform = 53
+)
+
+// LDAPServer is a fake LDAP server for sshserver.LDAPAuthenticator. It
+// supports simple binds against the entries added with AddEntry and
+// base-object searches of those entries by a bound client.
+type LDAPServer struct {
+	// URL is the address to set as LDAPAuthenticator.URL
+	URL string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	entries map[string]*ldapEntry
+	binds   int
+	conns   map[net.Conn]bool
+	closed  bool
+}
+
+type ldapEntry struct {
+	password string
+	attrs    map[string][]string
+}
+
+// NewLDAPServer starts an LDAP server on a local port. Like
+// httptest.NewServer, it panics if it cannot listen.
+func NewLDAPServer() *LDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("authtest: failed to listen: %v", err))
+	}
+
+	l := &LDAPServer{
+		URL:      "ldap://" + listener.Addr().String(),
+		listener: listener,
+		entries:  make(map[string]*ldapEntry),
+		conns:    make(map[net.Conn]bool),
+	}
+	l.wg.Add(1)
+	go l.serve()
+	return l
+}
+
+// AddEntry adds an entry that can bind with password and whose attributes
+// can be read once bound
+func (l *LDAPServer) AddEntry(dn, password string, attrs map[string][]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[normalizeDN(dn)] = &ldapEntry{password: password, attrs: attrs}
+}
+
+// Binds returns the number of bind requests received so far
+func (l *LDAPServer) Binds() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.binds
+}
+
+// Close stops the server and closes open connections
+func (l *LDAPServer) Close() {
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+
+	l.listener.Close()
+	l.wg.Wait()
+}
+
+func (l *LDAPServer) serve() {
+	defer l.wg.Done()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = true
+		l.mu.Unlock()
+
+		l.wg.Add(1)
+		go l.handle(conn)
+	}
+}
+
+// handle answers the requests of one client until it unbinds or disconnects
+func (l *LDAPServer) handle(conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	bound := ""
+	for {
+		msg, err := ber.Read(r)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id, op := msg.Child(0).Int(), msg.Child(1)
+
+		reply := func(tag byte, children ...*ber.Packet) bool {
+			resp := ber.Sequence(ber.TagSequence, ber.Integer(ber.TagInteger, id), ber.Sequence(tag, children...))
+			_, err := conn.Write(resp.Bytes())
+			return err == nil
+		}
+		result := func(tag byte, code int64, message string) bool {
+			return reply(tag,
+				ber.Integer(ber.TagEnumerated, code),
+				ber.OctetString(ber.TagOctetString, ""),
+				ber.OctetString(ber.TagOctetString, message),
+			)
+		}
+
+		switch op.Tag {
+		case bindRequest:
+			code, message := l.bind(op)
+			if code == resultSuccess {
+				bound = normalizeDN(op.Child(1).String())
+			} else {
+				bound = ""
+			}
+			if !result(bindResponse, code, message) {
+				return
+			}
+		case searchRequest:
+			dn := normalizeDN(op.Child(0).String())
+			if bound == "" {
+				result(searchResultDone, resultInsufficientAccess, "bind required")
+				return
+			}
+
+			l.mu.Lock()
+			entry := l.entries[dn]
+			l.mu.Unlock()
+			if entry == nil || op.Child(1).Int() != 0 {
+				if !result(searchResultDone, resultNoSuchObject, "no such object") {
+					return
+				}
+				continue
+			}
+
+			attrs := ber.Sequence(ber.TagSequence)
+			for _, want := range op.Child(7).Children {
+				for name, values := range entry.attrs {
+					if !strings.EqualFold(name, want.String()) {
+						continue
+					}
+					set := ber.Sequence(ber.TagSet)
+					for _, value := range values {
+						set.Children = append(set.Children, ber.OctetString(ber.TagOctetString, value))
+					}
+					attrs.Children = append(attrs.Children, ber.Sequence(ber.TagSequence, ber.OctetString(ber.TagOctetString, name), set))
+				}
+			}
+			if !reply(searchResultEntry, ber.OctetString(ber.TagOctetString, op.Child(0).String()), attrs) {
+				return
+			}
+			if !result(searchResultDone, resultSuccess, "") {
+				return
+			}
+		default:
+			// An unbind ends the session; other operations are not needed by
+			// LDAPAuthenticator
+			return
+		}
+	}
+}
+
+// bind checks a bind request, returning the result code and message
+func (l *LDAPServer) bind(op *ber.Packet) (int64, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.binds++
+
+	if op.Child(0).Int() != 3 {
+		return resultProtocolError, "only LDAPv3 is supported"
+	}
+	auth := op.Child(2)
+	if auth.Tag != ber.ClassContext|0 {
+		return resultUnwillingToPerform, "only simple binds are supported"
+	}
+	if len(auth.Value) == 0 {
+		return resultUnwillingToPerform, "unauthenticated binds are not allowed"
+	}
+
+	entry := l.entries[normalizeDN(op.Child(1).String())]
+	if entry == nil || entry.password != auth.String() {
+		return resultInvalidCredentials, "invalid credentials"
+	}
+	return resultSuccess, ""
+}
+
+// normalizeDN lower-cases dn and removes spaces after separators, enough to
+// match the DNs tests use
+func normalizeDN(dn string) string {
+	dn = strings.ToLower(dn)
+	dn = strings.ReplaceAll(dn, ", ", ",")
+	return dn
+}
@@ -0,0 +1,206 @@
+package sshserver
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// loadTrustedCAKeys reads the CA keys trusted to sign user certificates, one
+// per line in authorized_keys format
+func loadTrustedCAKeys(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted CA keys file: %v", err)
+	}
+
+	keys := make(map[string]bool)
+	for len(data) > 0 {
+		// ParseAuthorizedKey skips lines it cannot parse and fails once no
+		// key is left
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		keys[string(key.Marshal())] = true
+		data = rest
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no trusted CA keys found in %s", path)
+	}
+	return keys, nil
+}
+
+// trustFiles holds Config.TrustedUserCAKeysFile and Config.RevokedKeysFile in
+// memory so they are not read on every authentication attempt. Like
+// FileKeyStore, it is reloaded by Watch and on SIGHUP.
+type trustFiles struct {
+	caFile      string
+	revokedFile string
+	logger      *log.Logger
+
+	mu      sync.RWMutex
+	cas     map[string]bool
+	revoked *revokedKeys
+	stamp   string
+}
+
+// newTrustFiles loads the CA keys and revoked keys files; either may be empty
+func newTrustFiles(caFile, revokedFile string, logger *log.Logger) (*trustFiles, error) {
+	t := &trustFiles{caFile: caFile, revokedFile: revokedFile, logger: logger}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reads the files again. On error the previously loaded keys are kept,
+// so a revocation list that is being replaced never lapses.
+func (t *trustFiles) Reload() error {
+	stamp, err := t.currentStamp()
+	if err != nil {
+		return err
+	}
+
+	var cas map[string]bool
+	if t.caFile != "" {
+		if cas, err = loadTrustedCAKeys(t.caFile); err != nil {
+			return err
+		}
+	}
+	var revoked *revokedKeys
+	if t.revokedFile != "" {
+		if revoked, err = loadRevokedKeys(t.revokedFile); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	t.cas, t.revoked, t.stamp = cas, revoked, stamp
+	t.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the files whenever their modification times change, checking
+// every interval until stop is closed
+func (t *trustFiles) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		stamp, err := t.currentStamp()
+		t.mu.RLock()
+		changed := err == nil && stamp != t.stamp
+		t.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := t.Reload(); err != nil {
+			t.logger.Printf("Failed to reload trusted CA and revoked keys: %v", err)
+			continue
+		}
+		t.logger.Printf("Reloaded trusted CA and revoked keys")
+	}
+}
+
+// keys returns the trusted CA keys and the revocation list, which are nil
+// when their file is not configured
+func (t *trustFiles) keys() (map[string]bool, *revokedKeys) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.cas, t.revoked
+}
+
+// currentStamp summarizes the modification times and sizes of the files so
+// Watch can tell when they change
+func (t *trustFiles) currentStamp() (string, error) {
+	var b strings.Builder
+	for _, path := range []string{t.caFile, t.revokedFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat %s: %v", path, err)
+		}
+		fmt.Fprintf(&b, "%d/%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return b.String(), nil
+}
+
+// validateCertificate authenticates key with an ssh.CertChecker trusting the
+// CAs in Config.TrustedUserCAKeysFile. Plain keys fall back to the key
+// store. The key itself must already have passed checkRevoked.
+func (s *Server) validateCertificate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	cas, revoked := s.trustFiles.keys()
+
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return cas[string(auth.Marshal())]
+		},
+		SupportedCriticalOptions: []string{forceCommandOption, sourceAddressOption},
+	}
+	if revoked != nil {
+		checker.IsRevoked = revoked.certRevoked
+	}
+	if s.keyStore != nil {
+		checker.UserKeyFallback = s.validateAuthorizedKey
+	}
+
+	cert, isCert := key.(*ssh.Certificate)
+	if isCert && len(cert.ValidPrincipals) == 0 {
+		s.logger.Printf("Rejected certificate %q without principals for user %s", cert.KeyId, conn.User())
+		return nil, fmt.Errorf("certificate authentication failed for %q", conn.User())
+	}
+
+	perms, err := checker.Authenticate(conn, key)
+	if !isCert || err != nil {
+		if err != nil && isCert {
+			s.logger.Printf("Certificate authentication failed for user %s: %v", conn.User(), err)
+		}
+		return perms, err
+	}
+
+	if addrs := cert.CriticalOptions[sourceAddressOption]; addrs != "" {
+		if err := checkSourceAddress(conn.RemoteAddr(), addrs); err != nil {
+			s.logger.Printf("Certificate %q rejected for user %s: %v", cert.KeyId, conn.User(), err)
+			return nil, fmt.Errorf("certificate authentication failed for %q", conn.User())
+		}
+	}
+
+	granted := &ssh.Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      make(map[string]string),
+	}
+	for k, v := range perms.CriticalOptions {
+		granted.CriticalOptions[k] = v
+	}
+	for k, v := range perms.Extensions {
+		// Only standard permissions and vendor extensions, so certificates
+		// cannot set extensions the server records itself (e.g. "account")
+		if strings.HasPrefix(k, "permit-") || strings.Contains(k, "@") {
+			granted.Extensions[k] = v
+		}
+	}
+	granted.Extensions["pubkey-fp"] = ssh.FingerprintSHA256(cert.Key)
+	granted.Extensions["cert-key-id"] = cert.KeyId
+	granted.Extensions["cert-serial"] = strconv.FormatUint(cert.Serial, 10)
+	granted.Extensions[restrictExtension] = ""
+
+	s.logger.Printf("Certificate authentication successful for user %s (key ID %q, serial %d)", conn.User(), cert.KeyId, cert.Serial)
+	return granted, nil
+}
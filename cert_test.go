@@ -0,0 +1,151 @@
+package sshserver
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTrustServer returns a server trusting ca and revoking the keys in
+// revoked, with key authorized in its authorized_keys file
+func newTrustServer(t *testing.T, ca ssh.Signer, key ssh.PublicKey, revoked ...ssh.PublicKey) (*Server, *bytes.Buffer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pub")
+	revokedFile := filepath.Join(dir, "revoked")
+	keysFile := filepath.Join(dir, "authorized_keys")
+
+	if err := os.WriteFile(caFile, ssh.MarshalAuthorizedKey(ca.PublicKey()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var list []byte
+	for _, k := range revoked {
+		list = append(list, ssh.MarshalAuthorizedKey(k)...)
+	}
+	if err := os.WriteFile(revokedFile, list, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keysFile, ssh.MarshalAuthorizedKey(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	s := &Server{
+		config: &Config{TrustedUserCAKeysFile: caFile, RevokedKeysFile: revokedFile},
+		logger: log.New(&logs, "", 0),
+	}
+	var err error
+	if s.trustFiles, err = newTrustFiles(caFile, revokedFile, s.logger); err != nil {
+		t.Fatal(err)
+	}
+	if s.keyStore, err = NewFileKeyStore(keysFile, "", s.logger); err != nil {
+		t.Fatal(err)
+	}
+	return s, &logs, revokedFile
+}
+
+func TestValidatePublicKeyCertificates(t *testing.T) {
+	ca := newTestSigner(t)
+	plain, revokedKey := newTestPublicKey(t), newTestPublicKey(t)
+	s, _, _ := newTrustServer(t, ca, plain, revokedKey)
+
+	if _, err := s.validatePublicKey(testConn{"alice"}, newTestCert(t, ca, 1, "laptop")); err != nil {
+		t.Errorf("certificate rejected: %v", err)
+	}
+	if _, err := s.validatePublicKey(testConn{"bob"}, newTestCert(t, ca, 1, "laptop")); err == nil {
+		t.Error("certificate accepted for a user who is not a principal")
+	}
+	if _, err := s.validatePublicKey(testConn{"alice"}, newTestCert(t, newTestSigner(t), 1, "laptop")); err == nil {
+		t.Error("certificate from an untrusted CA accepted")
+	}
+	if _, err := s.validatePublicKey(testConn{"alice"}, plain); err != nil {
+		t.Errorf("authorized key rejected: %v", err)
+	}
+	if _, err := s.validatePublicKey(testConn{"alice"}, revokedKey); err == nil {
+		t.Error("revoked key accepted")
+	}
+}
+
+func TestTrustFilesReload(t *testing.T) {
+	ca := newTestSigner(t)
+	key, revokedKey := newTestPublicKey(t), newTestPublicKey(t)
+	s, _, revokedFile := newTrustServer(t, ca, key, revokedKey)
+
+	// The files are read once; a deleted list keeps the previous one
+	if err := os.Remove(revokedFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkRevoked(testConn{"alice"}, revokedKey); err == nil {
+		t.Error("revoked key accepted after the list was deleted")
+	}
+	if err := s.trustFiles.Reload(); err == nil {
+		t.Error("reload of a missing list succeeded")
+	}
+	if err := s.checkRevoked(testConn{"alice"}, revokedKey); err == nil {
+		t.Error("failed reload dropped the revocation list")
+	}
+
+	if err := os.WriteFile(revokedFile, ssh.MarshalAuthorizedKey(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.trustFiles.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if s.checkRevoked(testConn{"alice"}, revokedKey) != nil || s.checkRevoked(testConn{"alice"}, key) == nil {
+		t.Error("reload did not replace the revocation list")
+	}
+}
+
+func TestTrustFilesWatch(t *testing.T) {
+	ca := newTestSigner(t)
+	key := newTestPublicKey(t)
+	s, _, revokedFile := newTrustServer(t, ca, key)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.trustFiles.Watch(5*time.Millisecond, stop)
+
+	// Make sure the new modification time differs
+	time.Sleep(10 * time.Millisecond)
+	if err := os.WriteFile(revokedFile, ssh.MarshalAuthorizedKey(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.checkRevoked(testConn{"alice"}, key) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("changed revocation list was not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestExternalPublicKeyRevocationCheckedOnce(t *testing.T) {
+	ca := newTestSigner(t)
+	key, revokedKey := newTestPublicKey(t), newTestPublicKey(t)
+	s, logs, _ := newTrustServer(t, ca, key, revokedKey)
+	s.authenticator = &countingAuthenticator{err: ErrUnsupportedMethod}
+	cb := s.externalAuthCallbacks(ssh.ServerAuthCallbacks{PublicKeyCallback: s.validatePublicKey})
+
+	if _, err := cb.PublicKeyCallback(testConn{"alice"}, revokedKey); err == nil {
+		t.Fatal("revoked key accepted")
+	}
+	if n := strings.Count(logs.String(), "Rejected revoked key"); n != 1 {
+		t.Errorf("revocation checked %d times", n)
+	}
+	if s.authenticator.(*countingAuthenticator).calls != 0 {
+		t.Error("revoked key was sent to the authenticator")
+	}
+
+	// Unsupported by the authenticator, the key falls back to the built-in
+	// checks
+	if _, err := cb.PublicKeyCallback(testConn{"alice"}, key); err != nil {
+		t.Errorf("authorized key rejected: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package sshserver
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Question is a prompt shown to the user during keyboard-interactive
+// authentication
+type Question struct {
+	// Prompt is the text shown before the answer, e.g. "Verification code: "
+	Prompt string
+	// Echo shows the answer as it is typed; leave it false for secrets
+	Echo bool
+}
+
+// Challenge is one round of keyboard-interactive questions. The client shows
+// all questions of a round together and returns one answer per question.
+type Challenge struct {
+	// Instruction is shown above the questions and may be empty
+	Instruction string
+	// Questions are asked in order
+	Questions []Question
+	// Validate checks the answers for user, in the order of Questions, and
+	// returns nil if they are correct. It is required: a challenge without
+	// it fails authentication.
+	Validate func(user string, answers []string) error
+}
+
+// ChallengeFlow defines the questions asked during keyboard-interactive
+// authentication, such as one-time passwords, security questions or approval
+// codes
+type ChallengeFlow interface {
+	// Challenges returns the rounds of questions user must answer, in order.
+	// Authentication succeeds when every round validates; returning no
+	// rounds fails it.
+	Challenges(user string) ([]Challenge, error)
+}
+
+// SetChallengeFlow enables keyboard-interactive authentication using flow,
+// replacing the TOTP flow configured with Config.TOTPSecretsFile
+func (s *Server) SetChallengeFlow(flow ChallengeFlow) {
+	s.challengeFlow = flow
+	s.updateAuthCallbacks()
+}
+
+func (s *Server) handleKeyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	s.logger.Printf("Keyboard interactive auth attempt from user %s", conn.User())
+
+	if s.challengeFlow == nil {
+		return nil, fmt.Errorf("keyboard-interactive authentication not supported")
+	}
+
+	if err := runChallengeFlow(s.challengeFlow, conn.User(), client); err != nil {
+		s.logger.Printf("Keyboard interactive authentication failed: %v", err)
+		return nil, fmt.Errorf("keyboard-interactive authentication failed for %q", conn.User())
+	}
+
+	s.logger.Printf("Keyboard interactive authentication successful for user: %s", conn.User())
+	return &ssh.Permissions{}, nil
+}
+
+// runChallengeFlow asks the client every challenge of flow for user
+func runChallengeFlow(flow ChallengeFlow, user string, client ssh.KeyboardInteractiveChallenge) error {
+	challenges, err := flow.Challenges(user)
+	if err != nil {
+		return err
+	}
+
+	// A login must never pass without a credential being checked
+	if len(challenges) == 0 {
+		return fmt.Errorf("no challenges for %q", user)
+	}
+	for i, challenge := range challenges {
+		if challenge.Validate == nil {
+			return fmt.Errorf("challenge %d for %q has no Validate function", i+1, user)
+		}
+	}
+
+	for _, challenge := range challenges {
+		prompts := make([]string, len(challenge.Questions))
+		echos := make([]bool, len(challenge.Questions))
+		for i, q := range challenge.Questions {
+			prompts[i] = q.Prompt
+			echos[i] = q.Echo
+		}
+
+		answers, err := client("", challenge.Instruction, prompts, echos)
+		if err != nil {
+			return err
+		}
+		if len(answers) != len(prompts) {
+			return fmt.Errorf("expected %d answers, got %d", len(prompts), len(answers))
+		}
+
+		if err := challenge.Validate(user, answers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
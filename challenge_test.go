@@ -0,0 +1,109 @@
+package sshserver
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// challengeFunc adapts a function to ChallengeFlow
+type challengeFunc func(user string) ([]Challenge, error)
+
+func (f challengeFunc) Challenges(user string) ([]Challenge, error) {
+	return f(user)
+}
+
+// answering returns a client answering every question with answer and
+// counting the rounds it was asked
+func answering(answer string, rounds *int) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		*rounds++
+		answers := make([]string, len(questions))
+		for i := range answers {
+			answers[i] = answer
+		}
+		return answers, nil
+	}
+}
+
+func TestRunChallengeFlow(t *testing.T) {
+	var got [][]string
+	flow := challengeFunc(func(user string) ([]Challenge, error) {
+		validate := func(user string, answers []string) error {
+			got = append(got, answers)
+			if answers[0] != "42" {
+				return errors.New("wrong answer")
+			}
+			return nil
+		}
+		return []Challenge{
+			{Questions: []Question{{Prompt: "a: "}, {Prompt: "b: "}}, Validate: validate},
+			{Questions: []Question{{Prompt: "c: "}}, Validate: validate},
+		}, nil
+	})
+
+	var rounds int
+	if err := runChallengeFlow(flow, "alice", answering("42", &rounds)); err != nil {
+		t.Fatal(err)
+	}
+	if rounds != 2 || !reflect.DeepEqual(got, [][]string{{"42", "42"}, {"42"}}) {
+		t.Errorf("got %d rounds with answers %q", rounds, got)
+	}
+
+	rounds = 0
+	if err := runChallengeFlow(flow, "alice", answering("7", &rounds)); err == nil {
+		t.Error("wrong answer accepted")
+	}
+	if rounds != 1 {
+		t.Errorf("asked %d rounds after a failed one", rounds)
+	}
+}
+
+func TestRunChallengeFlowFailsClosed(t *testing.T) {
+	tests := map[string][]Challenge{
+		"no challenges":    nil,
+		"empty challenges": {},
+		"missing Validate": {{Questions: []Question{{Prompt: "Code: "}}}},
+		"later challenge missing Validate": {
+			{Questions: []Question{{Prompt: "Code: "}}, Validate: func(string, []string) error { return nil }},
+			{Questions: []Question{{Prompt: "Again: "}}},
+		},
+	}
+	for name, challenges := range tests {
+		flow := challengeFunc(func(user string) ([]Challenge, error) {
+			return challenges, nil
+		})
+		var rounds int
+		if err := runChallengeFlow(flow, "alice", answering("x", &rounds)); err == nil {
+			t.Errorf("%s: login accepted", name)
+		}
+		if rounds != 0 {
+			t.Errorf("%s: client was asked %d rounds", name, rounds)
+		}
+	}
+
+	failing := challengeFunc(func(user string) ([]Challenge, error) {
+		return nil, errors.New("backend down")
+	})
+	var rounds int
+	if err := runChallengeFlow(failing, "alice", answering("x", &rounds)); err == nil {
+		t.Error("login accepted when the flow failed")
+	}
+}
+
+func TestRunChallengeFlowAnswerCount(t *testing.T) {
+	flow := challengeFunc(func(user string) ([]Challenge, error) {
+		return []Challenge{{
+			Questions: []Question{{Prompt: "a: "}, {Prompt: "b: "}},
+			Validate:  func(string, []string) error { return nil },
+		}}, nil
+	})
+	short := func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		return []string{"only one"}, nil
+	}
+	if err := runChallengeFlow(flow, "alice", short); err == nil {
+		t.Error("missing answer accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package sshserver
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Completer is implemented by command handlers that can offer Tab completion
+// in the interactive shell
+type Completer interface {
+	// Complete returns the candidates for the word ending at the cursor, where
+	// pos is the byte offset of the cursor in line. Each candidate replaces
+	// that whole word.
+	Complete(line string, pos int) []string
+}
+
+// SessionCompleter is implemented by handlers whose completions depend on the
+// session, such as commands only some users may run. When a handler
+// implements it, the shell calls CompleteSession instead of
+// Completer.Complete.
+type SessionCompleter interface {
+	// CompleteSession is Completer.Complete on behalf of sess
+	CompleteSession(sess *Session, line string, pos int) []string
+}
+
+// sessionCompleter binds a SessionCompleter to a session
+type sessionCompleter struct {
+	completer SessionCompleter
+	sess      *Session
+}
+
+// Complete implements Completer.Complete
+func (c sessionCompleter) Complete(line string, pos int) []string {
+	return c.completer.CompleteSession(c.sess, line, pos)
+}
+
+// wordBefore returns the start offset of the word that ends at pos
+func wordBefore(line string, pos int) int {
+	return strings.LastIndexAny(line[:pos], " \t") + 1
+}
+
+// commonPrefix returns the longest prefix shared by all candidates
+func commonPrefix(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			// Trim whole runes so the prefix never ends inside a character
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// formatColumns lays candidates out in columns that fit within width, the way
+// shells list ambiguous completions
+func formatColumns(candidates []string, width int) string {
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+
+	colWidth := 0
+	for _, c := range sorted {
+		if w := stringWidth(c); w > colWidth {
+			colWidth = w
+		}
+	}
+	colWidth += 2
+
+	cols := width / colWidth
+	if cols < 1 {
+		cols = 1
+	}
+	rows := (len(sorted) + cols - 1) / cols
+
+	var b strings.Builder
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			i := col*rows + row
+			if i >= len(sorted) {
+				break
+			}
+			b.WriteString(sorted[i])
+			if col < cols-1 && i+rows < len(sorted) {
+				b.WriteString(strings.Repeat(" ", colWidth-stringWidth(sorted[i])))
+			}
+		}
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package sshserver
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// completerFunc adapts a function to Completer
+type completerFunc func(line string, pos int) []string
+
+func (f completerFunc) Complete(line string, pos int) []string {
+	return f(line, pos)
+}
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		candidates []string
+		want       string
+	}{
+		{nil, ""},
+		{[]string{"status"}, "status"},
+		{[]string{"status", "stats", "start"}, "sta"},
+		{[]string{"help", "exit"}, ""},
+		// Candidates differing inside a multi-byte character share no
+		// partial bytes of it
+		{[]string{"é1", "è2"}, ""},
+		{[]string{"café-a", "cafè-b"}, "caf"},
+		{[]string{"日本語", "日本人"}, "日本"},
+		{[]string{"ключ1", "ключ2"}, "ключ"},
+	}
+	for _, tt := range tests {
+		if got := commonPrefix(tt.candidates); got != tt.want {
+			t.Errorf("commonPrefix(%q) = %q, want %q", tt.candidates, got, tt.want)
+		}
+	}
+}
+
+func TestWordBefore(t *testing.T) {
+	tests := []struct {
+		line string
+		pos  int
+		want int
+	}{
+		{"", 0, 0},
+		{"sta", 3, 0},
+		{"help sta", 8, 5},
+		{"help\tsta", 8, 5},
+		{"help ", 5, 5},
+		{"héllo wö", len("héllo wö"), len("héllo ")},
+	}
+	for _, tt := range tests {
+		if got := wordBefore(tt.line, tt.pos); got != tt.want {
+			t.Errorf("wordBefore(%q, %d) = %d, want %d", tt.line, tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestFormatColumns(t *testing.T) {
+	got := formatColumns([]string{"ee", "a", "dddd", "ccc", "bb"}, 18)
+	want := "a     ccc   ee\r\nbb    dddd\r\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := formatColumns([]string{"much-too-wide"}, 4); got != "much-too-wide\r\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLineEditorCompletesWholeRunes(t *testing.T) {
+	e := newLineEditor(strings.NewReader("\t\r"), io.Discard)
+	e.completer = completerFunc(func(line string, pos int) []string {
+		return []string{"é1", "è2"}
+	})
+
+	line, err := e.readLine("> ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "" {
+		t.Errorf("got %q, want an empty line", line)
+	}
+}
@@ -2,8 +2,11 @@ package sshserver
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"time"
 )
 
 // Config holds the SSH server configuration
@@ -17,16 +20,197 @@ type Config struct {
 	// AuthorizedKeysFile is the path to the authorized_keys file
 	AuthorizedKeysFile string
 
+	// AuthorizedKeysDir is the path to a directory of per-user key files
+	// named <user>.pub, in authorized_keys format. Keys in a user's file
+	// only authenticate that user.
+	AuthorizedKeysDir string
+
+	// KeyReloadInterval is how often AuthorizedKeysFile, AuthorizedKeysDir,
+	// TrustedUserCAKeysFile and RevokedKeysFile are checked for changes. Zero
+	// means every 5 seconds; a negative value disables reloading.
+	KeyReloadInterval time.Duration
+
+	// ReloadOnSIGHUP reloads the authorized keys, trusted CA keys and revoked
+	// keys when the process receives SIGHUP
+	ReloadOnSIGHUP bool
+
+	// UsersFile is the path to a user directory file mapping user names to
+	// accounts with groups and roles. When set, only listed users can log in
+	// and public keys only work for the account owning them. See
+	// FileUserDirectory for the format.
+	UsersFile string
+
+	// TrustedUserCAKeysFile is the path to a file of CA public keys, one per
+	// line, trusted to sign OpenSSH user certificates. A certificate is
+	// accepted when the login user is one of its principals, it is within its
+	// validity window and it is not revoked. Its force-command and
+	// source-address critical options are enforced, and it only grants the
+	// features listed in its extensions (permit-pty, permit-port-forwarding,
+	// permit-agent-forwarding). Plain keys are still checked against
+	// AuthorizedKeysFile if set.
+	TrustedUserCAKeysFile string
+
+	// RevokedKeysFile is the path to a list of revoked keys and certificates,
+	// either an OpenSSH KRL generated with "ssh-keygen -k" or a text file with
+	// one public key or SHA256 fingerprint per line. If a changed file cannot
+	// be loaded, the previous list stays in force.
+	RevokedKeysFile string
+
 	// NoClientAuth disables client authentication if set to true
 	NoClientAuth bool
 
+	// PasswordFile is the path to an htpasswd-style file enabling password
+	// authentication. See HtpasswdAuthenticator for the format.
+	PasswordFile string
+
 	// AllowKeyboardInteractive enables keyboard-interactive authentication
 	AllowKeyboardInteractive bool
 
+	// TOTPSecretsFile is the path to a file of TOTP secrets. When set along
+	// with AllowKeyboardInteractive, keyboard-interactive authentication asks
+	// for a one-time password. See TOTPFlow for the format.
+	TOTPSecretsFile string
+
+	// AuthenticationMethods requires users to pass several authentication
+	// methods, like sshd's AuthenticationMethods. Keys are user names,
+	// "@group" for members of a group in Groups, or "*" for everyone else.
+	// Values are space-separated alternatives, each a comma-separated list of
+	// methods to pass in order (e.g. "publickey,keyboard-interactive
+	// publickey,password"). Users without an entry need a single method.
+	AuthenticationMethods map[string]string
+
+	// Groups maps the group names used in AuthenticationMethods to their
+	// members. Groups of accounts in the user directory count as well.
+	Groups map[string][]string
+
+	// AcceptEnv lists the environment variables clients may send with "env"
+	// requests, as glob patterns (e.g. "LANG", "LC_*"). Other variables are
+	// rejected.
+	AcceptEnv []string
+
+	// HistoryDir is the directory where interactive shell history is saved,
+	// one file per user. History is kept in memory only when empty.
+	HistoryDir string
+
+	// SFTP enables the "sftp" subsystem when set
+	SFTP *SFTPConfig
+
+	// SCP enables uploads and downloads with legacy scp clients ("scp -O"
+	// with OpenSSH 9 and later) when set
+	SCP *SCPConfig
+
+	// LocalForwarding enables local port forwarding ("ssh -L") when set
+	LocalForwarding *LocalForwardingConfig
+
+	// RemoteForwarding enables remote port forwarding ("ssh -R") when set
+	RemoteForwarding *RemoteForwardingConfig
+
+	// StreamLocalForwarding enables forwarding of Unix domain sockets when set
+	StreamLocalForwarding *StreamLocalForwardingConfig
+
+	// AllowAgentForwarding lets clients forward their SSH agent ("ssh -A")
+	// so handlers can use it through Session.Agent
+	AllowAgentForwarding bool
+
+	// AgentForwardingPolicy, if set, decides which users may forward their
+	// agent when AllowAgentForwarding is enabled
+	AgentForwardingPolicy func(info SessionInfo) bool
+
 	// LogWriter is where log messages will be written
 	LogWriter *LogConfig
 }
 
+// SFTPConfig specifies what the SFTP subsystem serves
+type SFTPConfig struct {
+	// Root is the directory served to clients as "/". Clients can't reach
+	// files outside it.
+	Root string
+
+	// PerUserRoot gives every user a private directory named after them
+	// inside Root, created on first use
+	PerUserRoot bool
+
+	// ReadOnly rejects uploads and every other change to the filesystem
+	ReadOnly bool
+
+	// FileSystem, if set, returns the filesystem served to a session instead
+	// of Root. The filesystem is closed at the end of the session if it
+	// implements io.Closer.
+	FileSystem func(sess *Session) (FileSystem, error)
+}
+
+// SCPConfig specifies what scp clients can copy to and from
+type SCPConfig struct {
+	// Root is the directory scp paths are resolved against. Clients can't
+	// reach files outside it.
+	Root string
+
+	// PerUserRoot gives every user a private directory named after them
+	// inside Root, created on first use
+	PerUserRoot bool
+
+	// ReadOnly allows downloads only
+	ReadOnly bool
+
+	// MaxFileSize is the largest file a client may upload, in bytes. Zero
+	// means no limit.
+	MaxFileSize int64
+
+	// FileSystem, if set, returns the filesystem used for a session instead
+	// of Root. The filesystem is closed at the end of the session if it
+	// implements io.Closer.
+	FileSystem func(sess *Session) (FileSystem, error)
+}
+
+// LocalForwardingConfig controls which destinations clients can reach with
+// local port forwarding
+type LocalForwardingConfig struct {
+	// Allow reports whether the user may connect to host:port. It is
+	// required: every destination is denied when nil.
+	Allow func(info SessionInfo, host string, port uint32) bool
+
+	// Dial opens the connection to an allowed destination, replacing the
+	// default TCP dialer
+	Dial Dialer
+}
+
+// RemoteForwardingConfig controls where clients can open listeners with
+// remote port forwarding
+type RemoteForwardingConfig struct {
+	// Allow reports whether the user may listen on address:port, where an
+	// empty address or "*" means all interfaces and port 0 any free port.
+	// When nil, every port is allowed but listeners are only opened on the
+	// loopback interface, whatever address the client asks for.
+	Allow func(info SessionInfo, address string, port uint32) bool
+
+	// Listen opens the listener for an allowed request, replacing net.Listen
+	Listen func(network, address string) (net.Listener, error)
+}
+
+// StreamLocalForwardingConfig controls which Unix domain sockets clients can
+// connect to ("ssh -L port:/path/to/socket") or listen on
+// ("ssh -R /path/to/socket:host:port")
+type StreamLocalForwardingConfig struct {
+	// AllowedPaths lists the socket paths clients may use, as glob patterns
+	// (e.g. "/var/run/docker.sock", "/tmp/tunnels/*.sock"). Paths must be
+	// absolute; no socket can be used when the list is empty.
+	AllowedPaths []string
+
+	// Allow, if set, is also consulted for paths in AllowedPaths. listen is
+	// true for remote forwarding requests.
+	Allow func(info SessionInfo, socketPath string, listen bool) bool
+}
+
+// pathAllowed reports whether socketPath matches AllowedPaths
+func (c *StreamLocalForwardingConfig) pathAllowed(socketPath string) bool {
+	for _, pattern := range c.AllowedPaths {
+		if ok, _ := filepath.Match(pattern, socketPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // LogConfig specifies logging configuration
 type LogConfig struct {
 	// Enabled turns logging on/off
@@ -46,6 +230,7 @@ func DefaultConfig() *Config {
 		HostKeyFile:        "server_key",
 		AuthorizedKeysFile: "authorized_keys",
 		NoClientAuth:       false,
+		AcceptEnv:          []string{"LANG", "LC_*"},
 		LogWriter: &LogConfig{
 			Enabled:     true,
 			FilePath:    "ssh_server.log",
@@ -60,29 +245,106 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("listen address cannot be empty")
 	}
 
+	for _, pattern := range c.AcceptEnv {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid accept env pattern %q: %v", pattern, err)
+		}
+	}
+
+	if c.SFTP != nil && c.SFTP.Root == "" && c.SFTP.FileSystem == nil {
+		return fmt.Errorf("sftp requires a root directory or a filesystem")
+	}
+
+	for key, value := range c.AuthenticationMethods {
+		if _, err := parseAuthChains(value); err != nil {
+			return fmt.Errorf("invalid authentication methods for %q: %v", key, err)
+		}
+	}
+
+	if c.StreamLocalForwarding != nil {
+		for _, pattern := range c.StreamLocalForwarding.AllowedPaths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid socket path pattern %q: %v", pattern, err)
+			}
+		}
+	}
+
+	if c.SCP != nil {
+		if c.SCP.Root == "" && c.SCP.FileSystem == nil {
+			return fmt.Errorf("scp requires a root directory or a filesystem")
+		}
+		if c.SCP.MaxFileSize < 0 {
+			return fmt.Errorf("scp max file size cannot be negative")
+		}
+	}
+
 	if !c.NoClientAuth {
 		if c.HostKeyFile == "" {
 			return fmt.Errorf("host key file path cannot be empty when client auth is enabled")
 		}
 
-		if c.AuthorizedKeysFile == "" {
-			return fmt.Errorf("authorized keys file path cannot be empty when client auth is enabled")
-		}
-
 		// Check if host key file exists
 		if _, err := os.Stat(c.HostKeyFile); err != nil {
 			return fmt.Errorf("host key file not found at %s: %v", c.HostKeyFile, err)
 		}
 
 		// Check if authorized_keys file exists
-		if _, err := os.Stat(c.AuthorizedKeysFile); err != nil {
-			return fmt.Errorf("authorized keys file not found at %s: %v", c.AuthorizedKeysFile, err)
+		if c.AuthorizedKeysFile != "" {
+			if _, err := os.Stat(c.AuthorizedKeysFile); err != nil {
+				return fmt.Errorf("authorized keys file not found at %s: %v", c.AuthorizedKeysFile, err)
+			}
+		}
+
+		if c.AuthorizedKeysDir != "" {
+			if info, err := os.Stat(c.AuthorizedKeysDir); err != nil || !info.IsDir() {
+				return fmt.Errorf("authorized keys directory not found at %s", c.AuthorizedKeysDir)
+			}
+		}
+
+		if c.TrustedUserCAKeysFile != "" {
+			if _, err := loadTrustedCAKeys(c.TrustedUserCAKeysFile); err != nil {
+				return err
+			}
+		}
+
+		if c.RevokedKeysFile != "" {
+			if _, err := loadRevokedKeys(c.RevokedKeysFile); err != nil {
+				return err
+			}
+		}
+
+		if c.TOTPSecretsFile != "" {
+			if _, err := os.Stat(c.TOTPSecretsFile); err != nil {
+				return fmt.Errorf("TOTP secrets file not found at %s: %v", c.TOTPSecretsFile, err)
+			}
+		}
+
+		if c.UsersFile != "" {
+			if _, err := os.Stat(c.UsersFile); err != nil {
+				return fmt.Errorf("users file not found at %s: %v", c.UsersFile, err)
+			}
+		}
+
+		if c.PasswordFile != "" {
+			if _, err := os.Stat(c.PasswordFile); err != nil {
+				return fmt.Errorf("password file not found at %s: %v", c.PasswordFile, err)
+			}
 		}
 	}
 
 	return nil
 }
 
+// envAllowed reports whether the environment variable name matches AcceptEnv
+func (c *Config) envAllowed(name string) bool {
+	for _, pattern := range c.AcceptEnv {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // ResolvePath resolves a relative path to absolute
 func ResolvePath(path string) string {
 	if filepath.IsAbs(path) {
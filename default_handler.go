@@ -2,20 +2,31 @@ package sshserver
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 )
 
+// ExitPermissionDenied is the exit status of commands the user lacks the roles
+// to run
+const ExitPermissionDenied uint32 = 126
+
 // DefaultCommandHandler provides a basic implementation of CommandHandler
 type DefaultCommandHandler struct {
-	commands map[string]func() (string, error)
+	commands map[string]command
+}
+
+// command is a command registered with a DefaultCommandHandler
+type command struct {
+	run   func(account *Account) (string, error)
+	roles []string
 }
 
 // NewDefaultHandler creates a new DefaultCommandHandler with basic commands
 func NewDefaultHandler() *DefaultCommandHandler {
 	h := &DefaultCommandHandler{
-		commands: make(map[string]func() (string, error)),
+		commands: make(map[string]command),
 	}
 
 	// Register default commands
@@ -42,38 +53,112 @@ func NewDefaultHandler() *DefaultCommandHandler {
 		return fmt.Sprintf("Server uptime: %d days, %d hours, %d minutes", days, hours, minutes), nil
 	})
 
-	h.RegisterCommand("help", func() (string, error) {
-		var commands []string
-		for cmd := range h.commands {
-			commands = append(commands, cmd)
-		}
-		return fmt.Sprintf("Available commands: %s", strings.Join(commands, ", ")), nil
-	})
+	h.commands["help"] = command{run: func(account *Account) (string, error) {
+		return fmt.Sprintf("Available commands: %s", strings.Join(h.allowedCommands(account), ", ")), nil
+	}}
 
 	return h
 }
 
-// RegisterCommand adds a new command to the handler
-func (h *DefaultCommandHandler) RegisterCommand(name string, handler func() (string, error)) {
-	h.commands[name] = handler
+// RegisterCommand adds a new command to the handler. When roles are given,
+// only users whose account has at least one of them can run the command or
+// see it in help; other users get ExitPermissionDenied. Roles are checked
+// against the session's account, whether it came from the UserDirectory or
+// an Authenticator.
+func (h *DefaultCommandHandler) RegisterCommand(name string, handler func() (string, error), roles ...string) {
+	h.commands[name] = command{
+		run:   func(*Account) (string, error) { return handler() },
+		roles: roles,
+	}
 }
 
-// Execute implements CommandHandler.Execute
+// Execute implements CommandHandler.Execute. Without a session there is no
+// account, so only commands without roles can run.
 func (h *DefaultCommandHandler) Execute(cmd string) (string, uint32) {
+	return h.execute(nil, cmd)
+}
+
+// ExecuteSession implements SessionHandler.ExecuteSession, checking command
+// roles against the session's account
+func (h *DefaultCommandHandler) ExecuteSession(sess *Session, cmd string) (string, uint32) {
+	return h.execute(sess.Account, cmd)
+}
+
+// execute runs cmd on behalf of account
+func (h *DefaultCommandHandler) execute(account *Account, cmd string) (string, uint32) {
 	cmd = strings.TrimSpace(cmd)
 	if cmd == "" {
 		return "", 0
 	}
 
-	if handler, ok := h.commands[cmd]; ok {
-		output, err := handler()
-		if err != nil {
-			return fmt.Sprintf("Error: %v", err), 1
+	c, ok := h.commands[cmd]
+	if !ok {
+		return fmt.Sprintf("Unknown command: %s\nUse 'help' to see available commands", cmd), 1
+	}
+	if !c.allowed(account) {
+		return fmt.Sprintf("Permission denied: %s", cmd), ExitPermissionDenied
+	}
+
+	output, err := c.run(account)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), 1
+	}
+	return output, 0
+}
+
+// allowed reports whether account has one of the roles the command requires
+func (c command) allowed(account *Account) bool {
+	if len(c.roles) == 0 {
+		return true
+	}
+	for _, role := range c.roles {
+		if account.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedCommands returns the sorted names of the commands account may run
+func (h *DefaultCommandHandler) allowedCommands(account *Account) []string {
+	var names []string
+	for name, c := range h.commands {
+		if c.allowed(account) {
+			names = append(names, name)
 		}
-		return output, 0
 	}
+	sort.Strings(names)
+	return names
+}
 
-	return fmt.Sprintf("Unknown command: %s\nUse 'help' to see available commands", cmd), 1
+// Complete implements Completer.Complete by completing the names of commands
+// that need no roles
+func (h *DefaultCommandHandler) Complete(line string, pos int) []string {
+	return h.complete(nil, line, pos)
+}
+
+// CompleteSession implements SessionCompleter.CompleteSession by completing
+// the names of commands the session's account may run
+func (h *DefaultCommandHandler) CompleteSession(sess *Session, line string, pos int) []string {
+	return h.complete(sess.Account, line, pos)
+}
+
+// complete completes command names for account
+func (h *DefaultCommandHandler) complete(account *Account, line string, pos int) []string {
+	start := wordBefore(line, pos)
+	if strings.TrimSpace(line[:start]) != "" {
+		// Commands take no arguments, so only the first word is completed
+		return nil
+	}
+
+	prefix := line[start:pos]
+	var candidates []string
+	for _, cmd := range h.allowedCommands(account) {
+		if strings.HasPrefix(cmd, prefix) {
+			candidates = append(candidates, cmd)
+		}
+	}
+	return candidates
 }
 
 // GetPrompt implements CommandHandler.GetPrompt
@@ -0,0 +1,100 @@
+package sshserver
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newTestHandler returns a handler with an unrestricted "status", a "deploy"
+// for operators and admins and a failing "restart" for admins
+func newTestHandler() *DefaultCommandHandler {
+	h := &DefaultCommandHandler{commands: make(map[string]command)}
+	h.RegisterCommand("status", func() (string, error) { return "ok", nil })
+	h.RegisterCommand("deploy", func() (string, error) { return "deployed", nil }, "operator", "admin")
+	h.RegisterCommand("restart", func() (string, error) { return "", errors.New("busy") }, "admin")
+	return h
+}
+
+func TestDefaultHandlerRoles(t *testing.T) {
+	operator := &Account{Name: "alice", Roles: []string{"operator"}}
+	guest := &Account{Name: "bob"}
+
+	tests := []struct {
+		name    string
+		account *Account
+		cmd     string
+		output  string
+		status  uint32
+	}{
+		{"operator status", operator, "status", "ok", 0},
+		{"operator deploy", operator, "deploy", "deployed", 0},
+		{"operator restart", operator, "restart", "Permission denied: restart", ExitPermissionDenied},
+		{"guest status", guest, "status", "ok", 0},
+		{"guest deploy", guest, "deploy", "Permission denied: deploy", ExitPermissionDenied},
+		{"no account status", nil, "status", "ok", 0},
+		{"no account deploy", nil, "deploy", "Permission denied: deploy", ExitPermissionDenied},
+		{"admin failing command", &Account{Roles: []string{"admin"}}, "restart", "Error: busy", 1},
+		{"unknown command", operator, "reboot", "Unknown command: reboot\nUse 'help' to see available commands", 1},
+		{"surrounding space", operator, "  deploy \n", "deployed", 0},
+		{"empty", nil, "  ", "", 0},
+	}
+	for _, tt := range tests {
+		sess := newTestSession(false)
+		sess.Account = tt.account
+		output, status := newTestHandler().ExecuteSession(sess, tt.cmd)
+		if output != tt.output || status != tt.status {
+			t.Errorf("%s: got %q, %d, want %q, %d", tt.name, output, status, tt.output, tt.status)
+		}
+	}
+}
+
+func TestDefaultHandlerExecuteWithoutSession(t *testing.T) {
+	h := newTestHandler()
+	if output, status := h.Execute("status"); output != "ok" || status != 0 {
+		t.Errorf("status: got %q, %d", output, status)
+	}
+	if _, status := h.Execute("deploy"); status != ExitPermissionDenied {
+		t.Errorf("deploy: got status %d, want %d", status, ExitPermissionDenied)
+	}
+}
+
+func TestDefaultHandlerHelp(t *testing.T) {
+	h := NewDefaultHandler()
+	h.RegisterCommand("deploy", func() (string, error) { return "", nil }, "operator", "admin")
+	h.RegisterCommand("restart", func() (string, error) { return "", nil }, "admin")
+
+	tests := []struct {
+		name    string
+		account *Account
+		want    string
+	}{
+		{"operator", &Account{Roles: []string{"operator"}}, "Available commands: deploy, getDate, hello, help, uptime"},
+		{"admin", &Account{Roles: []string{"admin"}}, "Available commands: deploy, getDate, hello, help, restart, uptime"},
+		{"no roles", &Account{}, "Available commands: getDate, hello, help, uptime"},
+		{"no account", nil, "Available commands: getDate, hello, help, uptime"},
+	}
+	for _, tt := range tests {
+		sess := newTestSession(false)
+		sess.Account = tt.account
+		if output, status := h.ExecuteSession(sess, "help"); output != tt.want || status != 0 {
+			t.Errorf("%s: got %q, %d, want %q", tt.name, output, status, tt.want)
+		}
+	}
+}
+
+func TestDefaultHandlerComplete(t *testing.T) {
+	h := newTestHandler()
+	sess := newTestSession(false)
+	sess.Account = &Account{Roles: []string{"admin"}}
+
+	if got, want := h.CompleteSession(sess, "re", 2), []string{"restart"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("admin: got %q, want %q", got, want)
+	}
+	if got := h.Complete("re", 2); got != nil {
+		t.Errorf("no account: got %q, want nothing", got)
+	}
+	if got := h.CompleteSession(sess, "status re", 9); got != nil {
+		t.Errorf("argument: got %q, want nothing", got)
+	}
+}
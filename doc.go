@@ -39,13 +39,41 @@
 //		return "Welcome to My SSH Server!"
 //	}
 //
+// Per-Session Handlers:
+//
+//	server.SetHandlerFactory(func(info sshserver.SessionInfo) sshserver.CommandHandler {
+//		return NewUserConsole(info.User)
+//	})
+//
+// SFTP and SCP:
+//
+//	config.SFTP = &sshserver.SFTPConfig{Root: "/srv/files", PerUserRoot: true}
+//	config.SCP = &sshserver.SCPConfig{Root: "/srv/files", PerUserRoot: true}
+//
+// Custom Subsystems:
+//
+//	server.RegisterSubsystem("netconf", func(sess *sshserver.Session, channel ssh.Channel) uint32 {
+//		return serveNetconf(sess, channel)
+//	})
+//
 // Features:
-//   - Public key authentication
+//   - Public key and password authentication
+//   - OpenSSH user certificates with KRL revocation
+//   - authorized_keys options (command=, from=, restrict, permitopen, ...)
+//   - Hot-reloading authorized keys with per-user key files
+//   - User accounts with groups and roles
+//   - Keyboard-interactive challenges with built-in TOTP
+//   - Multi-factor authentication chains per user or group
+//   - External LDAP and webhook authentication with result caching
 //   - Custom command handling
 //   - Configurable logging
 //   - Graceful shutdown
 //   - Interactive shell support
 //   - Command execution support
+//   - SFTP and SCP file transfer
+//   - Custom subsystems
+//   - Port forwarding with per-user policies
+//   - SSH agent forwarding
 //
 // The package follows Go idioms and best practices, making it easy to integrate
 // into existing projects while maintaining flexibility for custom implementations.
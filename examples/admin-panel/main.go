@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -15,11 +18,13 @@ import (
 	sshserver "repo.nusatek.id/sugeng/gosh"
 )
 
+// adminRole is the role accounts in the users file need to use the panel
+const adminRole = "admin"
+
 // AdminHandler implements administrative commands
 type AdminHandler struct {
 	startTime    time.Time
 	commandCount int
-	allowedUsers map[string]bool
 }
 
 // NewAdminHandler creates a new admin handler
@@ -27,13 +32,33 @@ func NewAdminHandler() *AdminHandler {
 	return &AdminHandler{
 		startTime:    time.Now(),
 		commandCount: 0,
-		allowedUsers: map[string]bool{
-			"admin": true,
-			"root":  true,
-		},
 	}
 }
 
+// isAdmin reports whether the session's account has the admin role
+func isAdmin(info sshserver.SessionInfo) bool {
+	return info.Account.HasRole(adminRole)
+}
+
+// ExecuteSession implements the SessionHandler interface, restricting the
+// panel to accounts with the admin role
+func (h *AdminHandler) ExecuteSession(sess *sshserver.Session, cmd string) (string, uint32) {
+	if !isAdmin(sess.SessionInfo) {
+		return fmt.Sprintf("Permission denied: user '%s' is not an administrator", sess.User), sshserver.ExitPermissionDenied
+	}
+
+	switch strings.TrimSpace(cmd) {
+	case "whoami":
+		h.commandCount++
+		return h.getSessionUser(sess), 0
+	case "agent-keys":
+		h.commandCount++
+		return h.getAgentKeys(sess)
+	}
+
+	return h.Execute(cmd)
+}
+
 // Execute implements the CommandHandler interface
 func (h *AdminHandler) Execute(cmd string) (string, uint32) {
 	h.commandCount++
@@ -289,6 +314,39 @@ func (h *AdminHandler) getDateTime() string {
 		now.Location().String())
 }
 
+func (h *AdminHandler) getSessionUser(sess *sshserver.Session) string {
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Logged in as: %s\n", sess.User))
+	result.WriteString(fmt.Sprintf("Connected from: %s\n", sess.RemoteAddr))
+	if sess.KeyFingerprint != "" {
+		result.WriteString(fmt.Sprintf("Key fingerprint: %s\n", sess.KeyFingerprint))
+	}
+	result.WriteString(h.getCurrentUser())
+	return result.String()
+}
+
+// getAgentKeys lists the keys in the operator's forwarded SSH agent, which
+// the panel could use to hop to downstream hosts
+func (h *AdminHandler) getAgentKeys(sess *sshserver.Session) (string, uint32) {
+	ag, err := sess.Agent()
+	if err != nil {
+		return "No forwarded agent: reconnect with ssh -A", 1
+	}
+	defer ag.Close()
+
+	keys, err := ag.List()
+	if err != nil {
+		return fmt.Sprintf("Failed to list agent keys: %v", err), 1
+	}
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("Forwarded agent keys (%d):\n", len(keys)))
+	for _, key := range keys {
+		result.WriteString(fmt.Sprintf("  %s %s\n", key.Type(), key.Comment))
+	}
+	return result.String(), 0
+}
+
 func (h *AdminHandler) getCurrentUser() string {
 	if user := os.Getenv("USER"); user != "" {
 		return fmt.Sprintf("Current user: %s", user)
@@ -341,7 +399,8 @@ func (h *AdminHandler) getHelp() string {
 - load                   Show load average
 - env [variable]         Show environment variables
 - date                   Show current date/time
-- whoami                 Show current user
+- whoami                 Show session and server user
+- agent-keys             List keys in your forwarded SSH agent (ssh -A)
 - stats                  Show server statistics
 - help                   Show this help message
 
@@ -364,17 +423,63 @@ func (h *AdminHandler) GetWelcomeMessage() string {
 		hostname, runtime.GOOS, runtime.GOARCH)
 }
 
+// adminHTTPHost is the name operators forward to with
+// "ssh -L 8080:admin.internal:80" to reach the internal admin endpoints
+const adminHTTPHost = "admin.internal"
+
+// startAdminHTTP serves the internal admin endpoints on a loopback port that
+// is only reachable through the SSH server
+func (h *AdminHandler) startAdminHTTP() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, h.getSystemStatus())
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	go http.Serve(listener, mux)
+	return listener.Addr().String(), nil
+}
+
 func main() {
 	// Create configuration
 	config := sshserver.DefaultConfig()
 	config.ListenAddress = ":2225"
 	config.HostKeyFile = "server_key"
 	config.AuthorizedKeysFile = "authorized_keys"
+	config.UsersFile = "users" // e.g. "admin:ops:admin:SHA256:..."
 	config.LogWriter.FilePath = "admin_server.log"
+	config.HistoryDir = "history"
 
 	// Create admin handler
 	handler := NewAdminHandler()
 
+	// Let administrators forward their SSH agent for "agent-keys"
+	config.AllowAgentForwarding = true
+	config.AgentForwardingPolicy = isAdmin
+
+	// Let administrators reach the internal admin endpoints with local port
+	// forwarding; nothing else can be forwarded
+	adminAddr, err := handler.startAdminHTTP()
+	if err != nil {
+		log.Fatalf("Failed to start admin endpoints: %v", err)
+	}
+	config.LocalForwarding = &sshserver.LocalForwardingConfig{
+		Allow: func(info sshserver.SessionInfo, host string, port uint32) bool {
+			return isAdmin(info) && host == adminHTTPHost && port == 80
+		},
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, adminAddr)
+		},
+	}
+
 	// Create and start server
 	server, err := sshserver.NewServer(config, handler)
 	if err != nil {
@@ -387,6 +492,7 @@ func main() {
 
 	log.Println("Admin Panel SSH server started on port 2225!")
 	log.Println("Connect with: ssh -p 2225 admin@localhost")
+	log.Println("Admin endpoints: ssh -p 2225 -N -L 8080:admin.internal:80 admin@localhost")
 
 	// Wait for interrupt
 	c := make(chan os.Signal, 1)
@@ -18,9 +18,15 @@ fi
 echo "Creating authorized_keys file..."
 cp client_key.pub authorized_keys
 
+# Create users file granting "admin" the admin role and the client key
+if [ ! -f "users" ]; then
+    echo "Creating users file..."
+    echo "admin:ops:admin:$(ssh-keygen -lf client_key.pub | cut -d' ' -f2)" > users
+fi
+
 # Set proper permissions
 chmod 600 server_key client_key
-chmod 644 server_key.pub client_key.pub authorized_keys
+chmod 644 server_key.pub client_key.pub authorized_keys users
 
 echo ""
 echo "Setup complete!"
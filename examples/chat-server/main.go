@@ -349,11 +349,14 @@ func main() {
 	config.AuthorizedKeysFile = "authorized_keys"
 	config.LogWriter.FilePath = "chat_server.log"
 
-	// Create a custom server that creates different handlers per connection
-	server, err := sshserver.NewServer(config, NewChatHandler("default"))
+	// Create a server that builds a separate handler for every session
+	server, err := sshserver.NewServer(config, nil)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
+	server.SetHandlerFactory(func(info sshserver.SessionInfo) sshserver.CommandHandler {
+		return NewChatHandler(info.User)
+	})
 
 	if err := server.Start(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
@@ -423,6 +423,61 @@ func (h *FileServerHandler) buildTree(dir, prefix string, result *strings.Builde
 	}
 }
 
+// Complete implements the Completer interface, completing command names for
+// the first word and paths under the root directory for the arguments
+func (h *FileServerHandler) Complete(line string, pos int) []string {
+	start := strings.LastIndex(line[:pos], " ") + 1
+	word := line[start:pos]
+
+	if strings.TrimSpace(line[:start]) == "" {
+		return h.completeCommand(word)
+	}
+	return h.completePath(word)
+}
+
+func (h *FileServerHandler) completeCommand(prefix string) []string {
+	commands := []string{"ls", "dir", "cd", "pwd", "cat", "type", "head", "tail",
+		"stat", "info", "find", "download", "tree", "help"}
+
+	var candidates []string
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd, prefix) {
+			candidates = append(candidates, cmd)
+		}
+	}
+	return candidates
+}
+
+func (h *FileServerHandler) completePath(word string) []string {
+	dirPart, prefix := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dirPart, prefix = word[:i+1], word[i+1:]
+	}
+
+	dir := h.resolvePath(dirPart)
+	if !h.isPathAllowed(dir) {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var candidates []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		name := dirPart + entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		candidates = append(candidates, name)
+	}
+	return candidates
+}
+
 // Helper methods
 func (h *FileServerHandler) resolvePath(path string) string {
 	if filepath.IsAbs(path) {
@@ -543,6 +598,9 @@ func main() {
 	config.HostKeyFile = "server_key"
 	config.AuthorizedKeysFile = "authorized_keys"
 	config.LogWriter.FilePath = "file_server.log"
+	config.HistoryDir = "history"
+	config.SFTP = &sshserver.SFTPConfig{Root: sampleDir}
+	config.SCP = &sshserver.SCPConfig{Root: sampleDir}
 
 	// Create file server handler
 	handler := NewFileServerHandler(sampleDir)
@@ -560,6 +618,8 @@ func main() {
 	log.Printf("File server started on port 2224!")
 	log.Printf("Serving files from: %s", sampleDir)
 	log.Println("Connect with: ssh -p 2224 user@localhost")
+	log.Println("Transfer files with: sftp -P 2224 user@localhost")
+	log.Println("                 or: scp -P 2224 user@localhost:readme.txt .")
 
 	// Wait for interrupt
 	c := make(chan os.Signal, 1)
@@ -469,14 +469,14 @@ func main() {
 	config.AuthorizedKeysFile = "authorized_keys"
 	config.LogWriter.FilePath = "game_server.log"
 
-	// Create game handler (username will be set per connection)
-	handler := NewGameHandler("player")
-
-	// Create and start server
-	server, err := sshserver.NewServer(config, handler)
+	// Create and start server, giving every player their own game state
+	server, err := sshserver.NewServer(config, nil)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
+	server.SetHandlerFactory(func(info sshserver.SessionInfo) sshserver.CommandHandler {
+		return NewGameHandler(info.User)
+	})
 
 	if err := server.Start(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -12,6 +13,7 @@ import (
 	"syscall"
 	"time"
 
+	"golang.org/x/crypto/ssh"
 	"repo.nusatek.id/sugeng/gosh"
 )
 
@@ -406,6 +408,61 @@ func (h *MonitoringHandler) getHealthCheck() string {
 		time.Now().Format("15:04:05"))
 }
 
+// HandleStream implements the StreamHandler interface so that "watch" can keep
+// refreshing until the user presses a key or disconnects
+func (h *MonitoringHandler) HandleStream(sess *sshserver.Session, cmd string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+	parts := strings.Fields(strings.TrimSpace(cmd))
+	if len(parts) == 0 || parts[0] != "watch" {
+		return sshserver.NewStreamAdapter(h).HandleStream(sess, cmd, stdin, stdout, stderr)
+	}
+
+	h.requests++
+	if len(parts) < 2 {
+		fmt.Fprintln(stderr, "Usage: watch <metric_type>\nExample: watch memory")
+		return 1
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1)
+		stdin.Read(buf)
+		close(stop)
+	}()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	// Fit the separator to the client's terminal, following window resizes
+	width := 40
+	if pty, ok := sess.Pty(); ok && pty.Width > 0 {
+		width = int(pty.Width)
+	}
+
+	fmt.Fprintf(stdout, "=== WATCHING %s (press any key to stop) ===\n", strings.ToUpper(parts[1]))
+	for {
+		fmt.Fprintln(stdout, strings.Repeat("-", width))
+		recent := metricsCollector.GetMetrics(parts[1], 1)
+		if len(recent) > 0 {
+			fmt.Fprintf(stdout, "[%s] %.2f %s\n",
+				recent[0].Timestamp.Format("15:04:05"),
+				recent[0].Value,
+				recent[0].Unit)
+		} else {
+			fmt.Fprintf(stdout, "[%s] no data for %s yet\n", time.Now().Format("15:04:05"), parts[1])
+		}
+
+		select {
+		case <-ticker.C:
+		case win := <-sess.WindowChanges():
+			width = int(win.Width)
+		case <-stop:
+			return 0
+		case <-sess.Context().Done():
+			return 0
+		}
+	}
+}
+
 func (h *MonitoringHandler) watchMetrics(args []string) (string, uint32) {
 	if len(args) == 0 {
 		return "Usage: watch <metric_type>\nExample: watch memory", 1
@@ -472,7 +529,7 @@ func (h *MonitoringHandler) getHelp() string {
 - requests           Show request statistics
 - health             Perform health check
 - alert              Check for alerts
-- watch <type>       Watch specific metric type
+- watch <type>       Watch specific metric type live
 - export [format]    Export metrics (json, csv)
 - help               Show this help
 
@@ -494,6 +551,62 @@ func (h *MonitoringHandler) GetWelcomeMessage() string {
 		"Type 'dashboard' for an overview or 'help' for commands."
 }
 
+// metricsRequest is a request sent to the "metrics" subsystem
+type metricsRequest struct {
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	Type   string `json:"type"`
+	Limit  int    `json:"limit"`
+}
+
+// metricsResponse answers a metricsRequest
+type metricsResponse struct {
+	ID     int         `json:"id"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// ServeMetrics implements the "metrics" subsystem, a line-delimited JSON
+// protocol for programs that collect metrics over SSH
+func (h *MonitoringHandler) ServeMetrics(sess *sshserver.Session, channel ssh.Channel) uint32 {
+	decoder := json.NewDecoder(channel)
+	encoder := json.NewEncoder(channel)
+
+	for {
+		var req metricsRequest
+		if err := decoder.Decode(&req); err != nil {
+			if err == io.EOF {
+				return 0
+			}
+			encoder.Encode(metricsResponse{Error: "invalid request: " + err.Error()})
+			return 1
+		}
+
+		resp := metricsResponse{ID: req.ID}
+		switch req.Method {
+		case "types":
+			resp.Result = []string{"memory", "runtime", "uptime", "requests"}
+		case "metrics":
+			if req.Limit <= 0 {
+				req.Limit = 10
+			}
+			resp.Result = metricsCollector.GetMetrics(req.Type, req.Limit)
+		case "health":
+			resp.Result = map[string]interface{}{
+				"status": "healthy",
+				"uptime": time.Since(h.startTime).String(),
+				"user":   sess.User,
+			}
+		default:
+			resp.Error = "unknown method: " + req.Method
+		}
+
+		if err := encoder.Encode(resp); err != nil {
+			return 1
+		}
+	}
+}
+
 func main() {
 	// Create configuration
 	config := sshserver.DefaultConfig()
@@ -510,6 +623,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
+	server.RegisterSubsystem("metrics", handler.ServeMetrics)
 
 	if err := server.Start(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
@@ -517,6 +631,7 @@ func main() {
 
 	log.Println("Monitoring Server started on port 2228!")
 	log.Println("Connect with: ssh -p 2228 monitor@localhost")
+	log.Println("Query metrics with: echo '{\"id\":1,\"method\":\"health\"}' | ssh -p 2228 -s monitor@localhost metrics")
 
 	// Wait for interrupt
 	c := make(chan os.Signal, 1)
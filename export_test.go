@@ -0,0 +1,9 @@
+package sshserver
+
+import "golang.org/x/crypto/ssh"
+
+// NewTestConn returns the connection metadata of a client logging in as user,
+// for the tests of package sshserver_test
+func NewTestConn(user string) ssh.ConnMetadata {
+	return testConn{user}
+}
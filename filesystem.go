@@ -0,0 +1,322 @@
+package sshserver
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File is an open file of a FileSystem
+type File interface {
+	fs.File
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+}
+
+// FileSystem is a writable filesystem served to clients over SFTP and SCP.
+// Like io/fs, names are slash-separated paths relative to the filesystem root
+// ("." is the root itself) and can never refer to anything outside it.
+type FileSystem interface {
+	fs.StatFS
+	fs.ReadDirFS
+
+	// OpenFile opens a file using os.OpenFile flags
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	// Mkdir creates a directory
+	Mkdir(name string, perm fs.FileMode) error
+	// Remove removes a file or an empty directory
+	Remove(name string) error
+	// Rename moves oldname to newname, replacing newname if it exists
+	Rename(oldname, newname string) error
+	// Chmod changes the permission bits of a file
+	Chmod(name string, mode fs.FileMode) error
+	// Chtimes changes the access and modification times of a file
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// dirFS is a FileSystem confined to a directory on disk. Every operation
+// goes through an os.Root, so symbolic links are resolved by the kernel
+// relative to the directory handle and can't be swapped to escape it between
+// a check and its use.
+type dirFS struct {
+	root *os.Root
+}
+
+// NewDirFS returns a FileSystem rooted at dir. Clients see dir as "/" and
+// can't reach files outside it, including through symbolic links. Close the
+// returned filesystem when it is no longer needed.
+func NewDirFS(dir string) (FileSystem, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", dir, err)
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", dir, err)
+	}
+
+	root, err := os.OpenRoot(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", dir, err)
+	}
+
+	return &dirFS{root: root}, nil
+}
+
+// Open implements fs.FS
+func (d *dirFS) Open(name string) (fs.File, error) {
+	return d.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Stat implements fs.StatFS
+func (d *dirFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	return d.root.Stat(name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (d *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	dir, err := d.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return dir.ReadDir(-1)
+}
+
+// OpenFile implements FileSystem.OpenFile
+func (d *dirFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f, err := d.root.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Mkdir implements FileSystem.Mkdir
+func (d *dirFS) Mkdir(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return d.root.Mkdir(name, perm)
+}
+
+// Remove implements FileSystem.Remove
+func (d *dirFS) Remove(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	return d.root.Remove(name)
+}
+
+// Rename implements FileSystem.Rename
+func (d *dirFS) Rename(oldname, newname string) error {
+	for _, name := range []string{oldname, newname} {
+		if !fs.ValidPath(name) || name == "." {
+			return &fs.PathError{Op: "rename", Path: name, Err: fs.ErrInvalid}
+		}
+	}
+	return d.root.Rename(oldname, newname)
+}
+
+// Chmod implements FileSystem.Chmod
+func (d *dirFS) Chmod(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrInvalid}
+	}
+	return d.root.Chmod(name, mode)
+}
+
+// Chtimes implements FileSystem.Chtimes
+func (d *dirFS) Chtimes(name string, atime, mtime time.Time) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrInvalid}
+	}
+	return d.root.Chtimes(name, atime, mtime)
+}
+
+// Close releases the directory handle
+func (d *dirFS) Close() error {
+	return d.root.Close()
+}
+
+// readOnlyFS serves an fs.FS as a FileSystem that rejects every change
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+// NewReadOnlyFS returns a FileSystem serving fsys, such as an embed.FS or
+// os.DirFS, or a writable FileSystem that should not be modified. All write
+// operations fail with fs.ErrPermission.
+func NewReadOnlyFS(fsys fs.FS) FileSystem {
+	return &readOnlyFS{fsys: fsys}
+}
+
+// Open implements fs.FS
+func (r *readOnlyFS) Open(name string) (fs.File, error) {
+	return r.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Stat implements fs.StatFS
+func (r *readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.fsys, name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (r *readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(r.fsys, name)
+}
+
+// OpenFile implements FileSystem.OpenFile, allowing only read-only access
+func (r *readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+
+	f, err := r.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{File: f}, nil
+}
+
+// Mkdir implements FileSystem.Mkdir
+func (r *readOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+
+// Remove implements FileSystem.Remove
+func (r *readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+// Rename implements FileSystem.Rename
+func (r *readOnlyFS) Rename(oldname, newname string) error {
+	return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrPermission}
+}
+
+// Chmod implements FileSystem.Chmod
+func (r *readOnlyFS) Chmod(name string, mode fs.FileMode) error {
+	return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrPermission}
+}
+
+// Chtimes implements FileSystem.Chtimes
+func (r *readOnlyFS) Chtimes(name string, atime, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrPermission}
+}
+
+// Close closes the underlying filesystem if it needs closing
+func (r *readOnlyFS) Close() error {
+	if c, ok := r.fsys.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// readOnlyFile adds random access to an fs.File and rejects writes
+type readOnlyFile struct {
+	fs.File
+	offset int64
+}
+
+// ReadAt implements io.ReaderAt, falling back to seeking or sequential reads
+// for files that don't support random access
+func (f *readOnlyFile) ReadAt(p []byte, off int64) (int, error) {
+	if ra, ok := f.File.(io.ReaderAt); ok {
+		return ra.ReadAt(p, off)
+	}
+
+	if seeker, ok := f.File.(io.Seeker); ok {
+		if _, err := seeker.Seek(off, io.SeekStart); err != nil {
+			return 0, err
+		}
+	} else if off != f.offset {
+		return 0, fmt.Errorf("file does not support random access")
+	}
+
+	n, err := io.ReadFull(f.File, p)
+	f.offset = off + int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write implements io.Writer
+func (f *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, fs.ErrPermission
+}
+
+// WriteAt implements io.WriterAt
+func (f *readOnlyFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, fs.ErrPermission
+}
+
+// openFileSystem returns the filesystem served to sess: the one built by
+// factory if set, otherwise root or the user's directory inside it
+func openFileSystem(sess *Session, root string, perUserRoot, readOnly bool, factory func(*Session) (FileSystem, error)) (FileSystem, error) {
+	var fsys FileSystem
+	if factory != nil {
+		var err error
+		if fsys, err = factory(sess); err != nil {
+			return nil, err
+		}
+	} else {
+		if perUserRoot {
+			dir, err := userDirName(sess.User)
+			if err != nil {
+				return nil, err
+			}
+			root = filepath.Join(root, dir)
+			if err := os.MkdirAll(root, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create %s: %v", root, err)
+			}
+		}
+
+		var err error
+		if fsys, err = NewDirFS(root); err != nil {
+			return nil, err
+		}
+	}
+
+	if readOnly {
+		fsys = NewReadOnlyFS(fsys)
+	}
+	return fsys, nil
+}
+
+// fsName converts a client path, absolute or relative to the root, into a
+// FileSystem name. Cleaning it as an absolute path means ".." can never climb
+// above the root.
+func fsName(p string) string {
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// userDirName returns the directory name used for user's private files
+func userDirName(user string) (string, error) {
+	if user == "" || user == "." || user == ".." {
+		return "", fmt.Errorf("invalid user name %q", user)
+	}
+	return url.PathEscape(user), nil
+}
@@ -0,0 +1,161 @@
+package sshserver
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirFSMetadata(t *testing.T) {
+	fsys, dir := newTestDirFS(t)
+	if err := os.WriteFile(filepath.Join(dir, "a"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Mkdir("sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fsys.Rename("a", "sub/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Chmod("sub/b", 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	if err := fsys.Chtimes("sub/b", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "sub", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("got mode %v mtime %v", info.Mode(), info.ModTime())
+	}
+}
+
+func TestDirFSConfinement(t *testing.T) {
+	fsys, dir := newTestDirFS(t)
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := os.WriteFile(secret, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("y"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := map[string]error{
+		"chmod through a directory link": fsys.Chmod("out/secret", 0777),
+		"chmod of a file link":           fsys.Chmod("link", 0777),
+		"chtimes through a link":         fsys.Chtimes("out/secret", time.Time{}, time.Time{}),
+		"rename out of the root":         fsys.Rename("f", "out/f"),
+		"rename into the root":           fsys.Rename("out/secret", "stolen"),
+		"open through a link":            openErr(fsys, "out/secret"),
+	}
+	for name, err := range checks {
+		if err == nil {
+			t.Errorf("%s succeeded", name)
+		}
+	}
+
+	info, err := os.Stat(secret)
+	if err != nil {
+		t.Fatalf("file outside the root was moved: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("file outside the root changed mode to %v", info.Mode())
+	}
+	if _, err := os.Stat(filepath.Join(outside, "f")); err == nil {
+		t.Error("file was moved outside the root")
+	}
+
+	for _, name := range []string{"../x", "/abs", ".", ""} {
+		if err := fsys.Rename("f", name); err == nil {
+			t.Errorf("rename to %q succeeded", name)
+		}
+		if name != "." {
+			if err := fsys.Chmod(name, 0600); err == nil {
+				t.Errorf("chmod of %q succeeded", name)
+			}
+		}
+	}
+}
+
+func openErr(fsys FileSystem, name string) error {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err == nil {
+		f.Close()
+	}
+	return err
+}
+
+func TestFSName(t *testing.T) {
+	for in, want := range map[string]string{
+		"":           ".",
+		"/":          ".",
+		".":          ".",
+		"a/b":        "a/b",
+		"/a/./b/":    "a/b",
+		"../../etc":  "etc",
+		"/a/../../b": "b",
+		"a//b/../c":  "a/c",
+	} {
+		if got := fsName(in); got != want {
+			t.Errorf("fsName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	fsys, dir := newTestDirFS(t)
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ro := NewReadOnlyFS(fsys)
+
+	f, err := ro.OpenFile("f", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if n, err := f.ReadAt(buf, 2); err != nil || string(buf[:n]) != "ta" {
+		t.Errorf("ReadAt = %q, %v", buf[:n], err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("write to a read-only file succeeded")
+	}
+	f.Close()
+
+	for name, err := range map[string]error{
+		"open for writing": openWriteErr(ro, "f"),
+		"mkdir":            ro.Mkdir("d", 0755),
+		"remove":           ro.Remove("f"),
+		"rename":           ro.Rename("f", "g"),
+		"chmod":            ro.Chmod("f", 0600),
+	} {
+		if err == nil || !os.IsPermission(err) {
+			t.Errorf("%s: got %v, want a permission error", name, err)
+		}
+	}
+	if _, err := fs.ReadFile(ro, "f"); err != nil {
+		t.Errorf("read failed: %v", err)
+	}
+}
+
+func openWriteErr(fsys FileSystem, name string) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY, 0)
+	if err == nil {
+		f.Close()
+	}
+	return err
+}
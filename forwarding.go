@@ -0,0 +1,258 @@
+package sshserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Dialer opens the connection for a forwarded channel. It has the signature of
+// net.Dialer.DialContext, so destinations can also be in-process services
+// (e.g. one end of a net.Pipe).
+type Dialer func(ctx context.Context, network, address string) (net.Conn, error)
+
+// handleDirectTCPIP serves a "direct-tcpip" channel opened by "ssh -L"
+func (s *Server) handleDirectTCPIP(ctx context.Context, newChannel ssh.NewChannel, sshConn *ssh.ServerConn) {
+	info := newSessionInfo(sshConn)
+
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		s.logger.Printf("Error parsing direct-tcpip payload: %v", err)
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	// Nothing is forwarded without an Allow policy
+	cfg := s.config.LocalForwarding
+	if !forwardPermitted(sshConn.Permissions, permitOpenExtension, payload.Host, payload.Port) ||
+		cfg.Allow == nil || !cfg.Allow(info, payload.Host, payload.Port) {
+		s.logger.Printf("Denied forwarding to %s:%d for user %s", payload.Host, payload.Port, info.User)
+		newChannel.Reject(ssh.Prohibited, "port forwarding denied")
+		return
+	}
+
+	dial := cfg.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	address := net.JoinHostPort(payload.Host, strconv.FormatUint(uint64(payload.Port), 10))
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		s.logger.Printf("Failed to connect to %s for user %s: %v", address, info.User, err)
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		s.logger.Printf("Could not accept channel: %v", err)
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	s.logger.Printf("Forwarding connection to %s for user %s", address, info.User)
+	proxy(ctx, channel, conn)
+}
+
+// proxy copies data between channel and conn until both directions are done
+// or ctx is cancelled, then closes both
+func proxy(ctx context.Context, channel ssh.Channel, conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			channel.Close()
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, channel)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			conn.Close()
+		}
+	}()
+	wg.Wait()
+
+	channel.Close()
+	conn.Close()
+}
+
+// remoteForwards tracks the listeners a connection opened with "tcpip-forward"
+type remoteForwards struct {
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+}
+
+// forwardRequest is the payload of "tcpip-forward" and "cancel-tcpip-forward"
+type forwardRequest struct {
+	Address string
+	Port    uint32
+}
+
+// handleTCPIPForward opens a listener for "ssh -R" and forwards the
+// connections it accepts back to the client. It returns the reply payload.
+func (s *Server) handleTCPIPForward(ctx context.Context, conn *ssh.ServerConn, forwards *remoteForwards, req forwardRequest) (bool, []byte) {
+	info := newSessionInfo(conn)
+	cfg := s.config.RemoteForwarding
+	if !forwardPermitted(conn.Permissions, permitListenExtension, req.Address, req.Port) ||
+		(cfg.Allow != nil && !cfg.Allow(info, req.Address, req.Port)) {
+		s.logger.Printf("Denied remote forwarding on %s:%d for user %s", req.Address, req.Port, info.User)
+		return false, nil
+	}
+
+	listen := cfg.Listen
+	if listen == nil {
+		listen = net.Listen
+	}
+
+	host := listenHost(req.Address, cfg.Allow != nil)
+	address := net.JoinHostPort(host, strconv.FormatUint(uint64(req.Port), 10))
+	listener, err := listen("tcp", address)
+	if err != nil {
+		s.logger.Printf("Failed to listen on %s for user %s: %v", address, info.User, err)
+		return false, nil
+	}
+
+	port := req.Port
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		port = uint32(addr.Port)
+	}
+
+	if !forwards.add(net.JoinHostPort(req.Address, strconv.FormatUint(uint64(port), 10)), listener) {
+		listener.Close()
+		return false, nil
+	}
+
+	s.logger.Printf("Forwarding connections on %s to user %s", listener.Addr(), info.User)
+	go s.acceptForwarded(ctx, conn, listener, "forwarded-tcpip", func(c net.Conn) []byte {
+		originHost, originPort := splitHostPort(c.RemoteAddr())
+		return ssh.Marshal(struct {
+			Address    string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}{req.Address, port, originHost, originPort})
+	})
+
+	var reply []byte
+	if req.Port == 0 {
+		reply = ssh.Marshal(struct{ Port uint32 }{port})
+	}
+	return true, reply
+}
+
+// listenHost returns the host a remote forward requested for address listens
+// on. Without an Allow policy listeners stay on the loopback interface, as with
+// OpenSSH's default "GatewayPorts no"; with one, "" and "*" mean every
+// interface.
+func listenHost(address string, policy bool) string {
+	if !policy {
+		if ip := net.ParseIP(address); ip != nil && ip.IsLoopback() {
+			return address
+		}
+		return "127.0.0.1"
+	}
+	if address == "*" {
+		return ""
+	}
+	return address
+}
+
+// cancelTCPIPForward closes the listener opened for req
+func (s *Server) cancelTCPIPForward(forwards *remoteForwards, req forwardRequest) bool {
+	return forwards.remove(net.JoinHostPort(req.Address, strconv.FormatUint(uint64(req.Port), 10)))
+}
+
+// add records listener under key, failing if key is already in use
+func (f *remoteForwards) add(key string, listener net.Listener) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.listeners[key]; exists {
+		return false
+	}
+	f.listeners[key] = listener
+	return true
+}
+
+// remove closes the listener recorded under key
+func (f *remoteForwards) remove(key string) bool {
+	f.mu.Lock()
+	listener, ok := f.listeners[key]
+	delete(f.listeners, key)
+	f.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// close closes every listener when the connection ends
+func (f *remoteForwards) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, listener := range f.listeners {
+		listener.Close()
+		delete(f.listeners, key)
+	}
+}
+
+// acceptForwarded opens a channel of channelType to the client for every
+// connection accepted by listener, with the extra data built by payload
+func (s *Server) acceptForwarded(ctx context.Context, conn *ssh.ServerConn, listener net.Listener, channelType string, payload func(net.Conn) []byte) {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			channel, requests, err := conn.OpenChannel(channelType, payload(c))
+			if err != nil {
+				s.logger.Printf("Failed to open %s channel to user %s: %v", channelType, conn.User(), err)
+				c.Close()
+				return
+			}
+			go ssh.DiscardRequests(requests)
+
+			proxy(ctx, channel, c)
+		}()
+	}
+}
+
+// splitHostPort returns the host and port of a TCP address
+func splitHostPort(addr net.Addr) (string, uint32) {
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String(), 0
+	}
+	port, _ := strconv.ParseUint(portStr, 10, 32)
+	return host, uint32(port)
+}
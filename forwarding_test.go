@@ -0,0 +1,121 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// stubConn is an ssh.Conn that only carries connection metadata
+type stubConn struct {
+	testConn
+}
+
+func (stubConn) SendRequest(string, bool, []byte) (bool, []byte, error) { return false, nil, nil }
+func (stubConn) OpenChannel(string, []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	return nil, nil, errors.New("not supported")
+}
+func (stubConn) Close() error { return nil }
+func (stubConn) Wait() error  { return nil }
+
+// rejectedChannel is a direct-tcpip ssh.NewChannel that records its rejection
+type rejectedChannel struct {
+	payload []byte
+	reason  ssh.RejectionReason
+}
+
+func (c *rejectedChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	return nil, nil, errors.New("not supported")
+}
+func (c *rejectedChannel) Reject(reason ssh.RejectionReason, message string) error {
+	c.reason = reason
+	return nil
+}
+func (c *rejectedChannel) ChannelType() string { return "direct-tcpip" }
+func (c *rejectedChannel) ExtraData() []byte   { return c.payload }
+
+func TestDirectTCPIPPolicy(t *testing.T) {
+	alice := func(info SessionInfo, host string, port uint32) bool {
+		return info.User == "alice" && host == "db.internal" && port == 5432
+	}
+	tests := []struct {
+		name   string
+		user   string
+		allow  func(SessionInfo, string, uint32) bool
+		permit string
+		host   string
+		want   ssh.RejectionReason
+	}{
+		{"no policy", "alice", nil, "", "db.internal", ssh.Prohibited},
+		{"allowed", "alice", alice, "", "db.internal", ssh.ConnectionFailed},
+		{"other destination", "alice", alice, "", "mail.internal", ssh.Prohibited},
+		{"other user", "bob", alice, "", "db.internal", ssh.Prohibited},
+		{"permitopen", "alice", alice, "web.internal:443", "db.internal", ssh.Prohibited},
+	}
+	for _, tt := range tests {
+		var dialed bool
+		s := &Server{
+			config: &Config{LocalForwarding: &LocalForwardingConfig{
+				Allow: tt.allow,
+				// Allowed destinations end with the dial failing
+				Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+					dialed = true
+					return nil, errors.New("unreachable")
+				},
+			}},
+			logger: log.New(&bytes.Buffer{}, "", 0),
+		}
+		perms := &ssh.Permissions{Extensions: map[string]string{}}
+		if tt.permit != "" {
+			perms.Extensions[permitOpenExtension] = tt.permit
+		}
+		conn := &ssh.ServerConn{Conn: stubConn{testConn{tt.user}}, Permissions: perms}
+		channel := &rejectedChannel{payload: ssh.Marshal(struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}{tt.host, 5432, "127.0.0.1", 50000})}
+
+		s.handleDirectTCPIP(context.Background(), channel, conn)
+		if channel.reason != tt.want {
+			t.Errorf("%s: rejected with %v, want %v", tt.name, channel.reason, tt.want)
+		}
+		if dialed != (tt.want == ssh.ConnectionFailed) {
+			t.Errorf("%s: dialed = %v", tt.name, dialed)
+		}
+	}
+}
+
+func TestListenHost(t *testing.T) {
+	tests := []struct {
+		address string
+		policy  bool
+		want    string
+	}{
+		// Without a policy nothing is exposed beyond loopback
+		{"", false, "127.0.0.1"},
+		{"*", false, "127.0.0.1"},
+		{"0.0.0.0", false, "127.0.0.1"},
+		{"::", false, "127.0.0.1"},
+		{"localhost", false, "127.0.0.1"},
+		{"192.0.2.1", false, "127.0.0.1"},
+		{"127.0.0.1", false, "127.0.0.1"},
+		{"::1", false, "::1"},
+		// A policy that allowed the address gets what was asked for
+		{"", true, ""},
+		{"*", true, ""},
+		{"localhost", true, "localhost"},
+		{"192.0.2.1", true, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		if got := listenHost(tt.address, tt.policy); got != tt.want {
+			t.Errorf("listenHost(%q, %v) = %q, want %q", tt.address, tt.policy, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 module repo.nusatek.id/sugeng/gosh
 
-go 1.24.3
+go 1.25.0
 
 require golang.org/x/crypto v0.38.0
 
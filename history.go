@@ -0,0 +1,132 @@
+package sshserver
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultHistorySize is the number of commands kept per user
+const defaultHistorySize = 1000
+
+// HistoryStore persists interactive shell history across connections
+type HistoryStore interface {
+	// Load returns the saved history for user, oldest command first
+	Load(user string) ([]string, error)
+	// Append records a command entered by user
+	Append(user string, line string) error
+}
+
+// FileHistoryStore keeps each user's shell history in its own file inside a
+// directory, similar to ~/.bash_history
+type FileHistoryStore struct {
+	dir        string
+	maxEntries int
+	mu         sync.Mutex
+}
+
+// NewFileHistoryStore creates a HistoryStore that writes history files to dir,
+// creating the directory if needed
+func NewFileHistoryStore(dir string) (*FileHistoryStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	return &FileHistoryStore{
+		dir:        dir,
+		maxEntries: defaultHistorySize,
+	}, nil
+}
+
+// Load implements HistoryStore.Load
+func (f *FileHistoryStore) Load(user string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path(user))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read history for %q: %v", user, err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) > f.maxEntries {
+		// Compact the file so it doesn't grow without bound
+		lines = lines[len(lines)-f.maxEntries:]
+		content := strings.Join(lines, "\n") + "\n"
+		if err := os.WriteFile(f.path(user), []byte(content), 0600); err != nil {
+			return nil, fmt.Errorf("failed to compact history for %q: %v", user, err)
+		}
+	}
+
+	return lines, nil
+}
+
+// Append implements HistoryStore.Append
+func (f *FileHistoryStore) Append(user string, line string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path(user), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history for %q: %v", user, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to write history for %q: %v", user, err)
+	}
+	return nil
+}
+
+// path returns the history file for user; the name is escaped so a username
+// can never point outside the history directory
+func (f *FileHistoryStore) path(user string) string {
+	return filepath.Join(f.dir, url.PathEscape(user)+".history")
+}
+
+// history is the in-memory command history of a shell session
+type history struct {
+	entries []string
+	max     int
+}
+
+func newHistory() *history {
+	return &history{max: defaultHistorySize}
+}
+
+// load replaces the history with previously saved entries
+func (h *history) load(entries []string) {
+	if len(entries) > h.max {
+		entries = entries[len(entries)-h.max:]
+	}
+	h.entries = append([]string(nil), entries...)
+}
+
+// add records line and reports whether it was added; blank lines and
+// immediate repeats are skipped
+func (h *history) add(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return false
+	}
+	if len(h.entries) > 0 && h.entries[len(h.entries)-1] == line {
+		return false
+	}
+
+	h.entries = append(h.entries, line)
+	if len(h.entries) > h.max {
+		h.entries = h.entries[len(h.entries)-h.max:]
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+package sshserver
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileHistoryStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	store, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines, err := store.Load("alice"); lines != nil || err != nil {
+		t.Errorf("user without history: got %q, %v", lines, err)
+	}
+
+	for _, line := range []string{"ls", "uptime", "ls"} {
+		if err := store.Append("alice", line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Append("bob", "help"); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines, err := store.Load("alice"); err != nil || !reflect.DeepEqual(lines, []string{"ls", "uptime", "ls"}) {
+		t.Errorf("alice: got %q, %v", lines, err)
+	}
+	if lines, err := store.Load("bob"); err != nil || !reflect.DeepEqual(lines, []string{"help"}) {
+		t.Errorf("bob: got %q, %v", lines, err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "alice.history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("history file mode is %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestFileHistoryStorePath(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user string
+		file string
+	}{
+		{"alice", "alice.history"},
+		{"first last", "first%20last.history"},
+		{"../escape", "..%2Fescape.history"},
+		{"a/b/c", "a%2Fb%2Fc.history"},
+		{"..", "...history"},
+	}
+	for _, tt := range tests {
+		if err := store.Append(tt.user, "ls"); err != nil {
+			t.Fatalf("%q: %v", tt.user, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, tt.file)); err != nil {
+			t.Errorf("%q: %v", tt.user, err)
+		}
+	}
+
+	// Every file stays directly inside the directory
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(tests) {
+		t.Errorf("got %d files, want %d", len(entries), len(tests))
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.history")); !os.IsNotExist(err) {
+		t.Errorf("history was written outside the directory")
+	}
+}
+
+func TestFileHistoryStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < defaultHistorySize+50; i++ {
+		if err := store.Append("alice", fmt.Sprintf("cmd %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines, err := store.Load("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != defaultHistorySize || lines[0] != "cmd 50" || lines[len(lines)-1] != fmt.Sprintf("cmd %d", defaultHistorySize+49) {
+		t.Fatalf("got %d entries from %q to %q", len(lines), lines[0], lines[len(lines)-1])
+	}
+
+	// Loading rewrote the file with only the kept entries
+	data, err := os.ReadFile(filepath.Join(dir, "alice.history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "\n"); got != defaultHistorySize {
+		t.Errorf("compacted file has %d lines, want %d", got, defaultHistorySize)
+	}
+	if !strings.HasPrefix(string(data), "cmd 50\n") {
+		t.Errorf("compacted file starts with %q", strings.SplitN(string(data), "\n", 2)[0])
+	}
+}
+
+func TestHistoryAdd(t *testing.T) {
+	h := newHistory()
+	h.max = 3
+
+	for _, tt := range []struct {
+		line  string
+		added bool
+	}{
+		{"ls", true},
+		{"ls", false},
+		{"", false},
+		{"   ", false},
+		{"uptime", true},
+		{"ls", true},
+		{"help", true},
+	} {
+		if added := h.add(tt.line); added != tt.added {
+			t.Errorf("add(%q) = %v, want %v", tt.line, added, tt.added)
+		}
+	}
+
+	// Only the newest entries are kept
+	if want := []string{"uptime", "ls", "help"}; !reflect.DeepEqual(h.entries, want) {
+		t.Errorf("got %q, want %q", h.entries, want)
+	}
+
+	h.load([]string{"a", "b", "c", "d"})
+	if want := []string{"b", "c", "d"}; !reflect.DeepEqual(h.entries, want) {
+		t.Errorf("after load: got %q, want %q", h.entries, want)
+	}
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	entries := []string{"git status", "ls -l", "git log", "uptime"}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"up", "\x1b[A\r", "uptime"},
+		{"up twice", "\x1b[A\x1b[A\r", "git log"},
+		{"up past oldest", strings.Repeat("\x10", 6) + "\r", "git status"},
+		{"down restores draft", "dra\x1b[A\x1b[A\x1b[Bft\x1b[B\x1b[Bft\r", "draft"},
+		{"edit recalled", "\x10\x7f\x7f\r", "upti"},
+		// Reverse search
+		{"search", "\x12git\r", "git log"},
+		{"search older", "\x12git\x12\r", "git status"},
+		{"search oldest", "\x12git\x12\x12\x12\r", "git status"},
+		{"search substring", "\x12-l\r", "ls -l"},
+		{"search failed", "\x12nothing\r", ""},
+		{"search backspace", "\x12lsx\x7f\r", "ls -l"},
+		{"search cancel", "draft\x12git\x07\r", "draft"},
+		{"search then edit", "\x12log\x05 -1\r", "git log -1"},
+		{"search cursor at match", "\x12stat\x1b[DX\r", "gitX status"},
+	}
+	for _, tt := range tests {
+		e := newLineEditor(strings.NewReader(tt.input), io.Discard)
+		e.history.load(entries)
+		line, err := e.readLine("> ")
+		if err != nil || line != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, line, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package sshserver
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// errInterrupt is returned by the line editor when the user presses Ctrl-C
+var errInterrupt = errors.New("interrupted")
+
+// channelInput reads a channel in the background so the shell can keep
+// watching for Ctrl-C while a command is running
+type channelInput struct {
+	chunks chan []byte
+	done   chan struct{}
+	err    error
+	rest   []byte
+}
+
+func newChannelInput(r io.Reader) *channelInput {
+	in := &channelInput{
+		chunks: make(chan []byte),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			buf := make([]byte, 1024)
+			n, err := r.Read(buf)
+			if n > 0 {
+				select {
+				case in.chunks <- buf[:n]:
+				case <-in.done:
+					return
+				}
+			}
+			if err != nil {
+				in.err = err
+				close(in.chunks)
+				return
+			}
+		}
+	}()
+
+	return in
+}
+
+// Read implements io.Reader for the line editor
+func (in *channelInput) Read(p []byte) (int, error) {
+	if len(in.rest) == 0 {
+		chunk, ok := <-in.chunks
+		if !ok {
+			return 0, in.err
+		}
+		in.rest = chunk
+	}
+
+	n := copy(p, in.rest)
+	in.rest = in.rest[n:]
+	return n, nil
+}
+
+// stop releases the background reader once the input is no longer needed
+func (in *channelInput) stop() {
+	close(in.done)
+}
+
+// takeRest returns data that was received but not yet read
+func (in *channelInput) takeRest() []byte {
+	rest := in.rest
+	in.rest = nil
+	return rest
+}
+
+// maxInputBuffer is how much input may wait for a command to read it. Beyond
+// that the relay stops reading the channel, so the client's SSH window fills
+// up and it stops sending.
+const maxInputBuffer = 64 << 10
+
+// inputBuffer is the stdin of a running command. Writes block while
+// maxInputBuffer bytes are waiting, until the command reads them or finishes.
+type inputBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	data     []byte
+	closed   bool
+	finished bool
+}
+
+func newInputBuffer() *inputBuffer {
+	b := &inputBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Read blocks until input is available or the buffer is closed
+func (b *inputBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.data) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	b.cond.Broadcast()
+	return n, nil
+}
+
+// Write queues input for the command, waiting while the buffer is full. Once
+// the command has finished, input is kept for whatever runs next.
+func (b *inputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.data) >= maxInputBuffer && !b.closed && !b.finished {
+		b.cond.Wait()
+	}
+	if b.closed && len(b.data) >= maxInputBuffer {
+		// Input after Ctrl-D is only kept as type-ahead for the shell; drop
+		// what doesn't fit rather than stall the relay
+		return len(p), nil
+	}
+
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// Close signals end of input to the command
+func (b *inputBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+	return nil
+}
+
+// finish releases writers once the command no longer reads its input
+func (b *inputBuffer) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.finished = true
+	b.cond.Broadcast()
+}
+
+// unread returns input the command never consumed
+func (b *inputBuffer) unread() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := b.data
+	b.data = nil
+	return data
+}
+
+// runCommand runs cmd through stream while relaying input from in to the
+// command's stdin. typeAhead is input that arrived before the command started.
+// When the client has a terminal attached, Ctrl-C interrupts the command and
+// Ctrl-D ends its input. It returns the exit status and any input the command
+// left unread.
+func runCommand(sess *Session, stream StreamHandler, cmd string, in *channelInput, typeAhead []byte, stdout, stderr io.Writer) (uint32, []byte) {
+	_, terminal := sess.Pty()
+
+	stdin := newInputBuffer()
+	relay := func(data []byte) {
+		if !terminal {
+			stdin.Write(data)
+			return
+		}
+		for _, c := range data {
+			switch c {
+			case keyCtrlC:
+				io.WriteString(stdout, "^C")
+				sess.signal(ssh.SIGINT)
+			case keyCtrlD:
+				stdin.Close()
+			default:
+				stdin.Write([]byte{c})
+			}
+		}
+	}
+	// The command's context must exist before type-ahead is relayed, so a
+	// Ctrl-C typed early still interrupts it
+	sess.beginCommand()
+	defer sess.endCommand()
+
+	done := make(chan uint32, 1)
+	go func() {
+		status := stream.HandleStream(sess, cmd, stdin, stdout, stderr)
+		stdin.finish()
+		done <- status
+	}()
+
+	relay(append(typeAhead, in.takeRest()...))
+
+	chunks := in.chunks
+	for {
+		select {
+		case status := <-done:
+			return status, stdin.unread()
+		case chunk, ok := <-chunks:
+			if !ok {
+				stdin.Close()
+				chunks = nil
+				continue
+			}
+			relay(chunk)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// streamFunc adapts a function to StreamHandler
+type streamFunc func(sess *Session, cmd string, stdin io.Reader, stdout, stderr io.Writer) uint32
+
+func (f streamFunc) HandleStream(sess *Session, cmd string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+	return f(sess, cmd, stdin, stdout, stderr)
+}
+
+// newTestSession returns a session without a connection, with a terminal
+// attached if pty is set
+func newTestSession(pty bool) *Session {
+	sess := &Session{
+		Extensions: make(map[string]string),
+		env:        make(map[string]string),
+		values:     make(map[string]interface{}),
+		signals:    make(chan ssh.Signal, 8),
+		windows:    make(chan Window, 1),
+	}
+	sess.ctx, sess.cancel = context.WithCancel(context.Background())
+	if pty {
+		sess.setPty(PtyInfo{Term: "xterm", Window: Window{Width: 80, Height: 24}})
+	}
+	return sess
+}
+
+func TestInputBufferBlocksWhenFull(t *testing.T) {
+	b := newInputBuffer()
+	b.Write(make([]byte, maxInputBuffer))
+
+	written := make(chan struct{})
+	go func() {
+		b.Write([]byte("more"))
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("write to a full buffer did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := b.Read(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("write still blocked after the command read input")
+	}
+}
+
+func TestInputBufferFinishReleasesWriters(t *testing.T) {
+	b := newInputBuffer()
+	b.Write(make([]byte, maxInputBuffer))
+
+	written := make(chan struct{})
+	go func() {
+		b.Write([]byte("next"))
+		close(written)
+	}()
+
+	b.finish()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("write still blocked after the command finished")
+	}
+	if rest := b.unread(); !bytes.HasSuffix(rest, []byte("next")) {
+		t.Errorf("input written after finish was lost")
+	}
+}
+
+func TestRunCommandTypeAheadInterrupt(t *testing.T) {
+	sess := newTestSession(true)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	in := newChannelInput(pr)
+	defer in.stop()
+
+	stream := streamFunc(func(sess *Session, cmd string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+		select {
+		case <-sess.Context().Done():
+			return 130
+		case <-time.After(2 * time.Second):
+			return 0
+		}
+	})
+
+	var out bytes.Buffer
+	status, _ := runCommand(sess, stream, "sleep", in, []byte{keyCtrlC}, &out, &out)
+	if status != 130 {
+		t.Errorf("Ctrl-C typed ahead did not interrupt the command (status %d)", status)
+	}
+}
+
+func TestRunCommandStdin(t *testing.T) {
+	sess := newTestSession(false)
+	in := newChannelInput(bytes.NewReader([]byte("hello")))
+	defer in.stop()
+
+	stream := streamFunc(func(sess *Session, cmd string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+		io.Copy(stdout, stdin)
+		return 0
+	})
+
+	var out bytes.Buffer
+	if status, _ := runCommand(sess, stream, "cat", in, []byte(">"), &out, &out); status != 0 {
+		t.Fatalf("status %d", status)
+	}
+	if out.String() != ">hello" {
+		t.Errorf("got %q", out.String())
+	}
+}
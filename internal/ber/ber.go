@@ -0,0 +1,189 @@
+// Package ber implements the subset of ASN.1 BER encoding used by LDAP
+// messages: single-byte tags, definite lengths, integers, booleans and octet
+// strings.
+package ber
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Universal tags
+const (
+	TagBoolean     = 0x01
+	TagInteger     = 0x02
+	TagOctetString = 0x04
+	TagNull        = 0x05
+	TagEnumerated  = 0x0a
+	TagSequence    = 0x30
+	TagSet         = 0x31
+)
+
+// Tag classes and the constructed bit, to combine with a tag number
+const (
+	ClassApplication = 0x40
+	ClassContext     = 0x80
+	Constructed      = 0x20
+)
+
+// maxLength bounds the size of a single element
+const maxLength = 16 << 20
+
+// Packet is a BER element. Constructed elements have Children; primitive
+// elements have Value.
+type Packet struct {
+	Tag      byte
+	Value    []byte
+	Children []*Packet
+}
+
+// Sequence returns a constructed element with tag and children
+func Sequence(tag byte, children ...*Packet) *Packet {
+	return &Packet{Tag: tag | Constructed, Children: children}
+}
+
+// OctetString returns a primitive element holding s
+func OctetString(tag byte, s string) *Packet {
+	return &Packet{Tag: tag, Value: []byte(s)}
+}
+
+// Integer returns a primitive element holding v in two's complement
+func Integer(tag byte, v int64) *Packet {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if (v < 128 && v >= -128) || len(b) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return &Packet{Tag: tag, Value: b}
+}
+
+// Boolean returns a primitive element holding v
+func Boolean(tag byte, v bool) *Packet {
+	if v {
+		return &Packet{Tag: tag, Value: []byte{0xff}}
+	}
+	return &Packet{Tag: tag, Value: []byte{0}}
+}
+
+// Int decodes the value of an integer or enumerated element
+func (p *Packet) Int() int64 {
+	var v int64
+	for i, b := range p.Value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+// String returns the value of a primitive element as a string
+func (p *Packet) String() string {
+	return string(p.Value)
+}
+
+// Child returns the i-th child, or an empty element if there is none, so
+// malformed messages decode as zero values instead of panicking
+func (p *Packet) Child(i int) *Packet {
+	if i < len(p.Children) {
+		return p.Children[i]
+	}
+	return &Packet{}
+}
+
+// Bytes encodes the element
+func (p *Packet) Bytes() []byte {
+	value := p.Value
+	if p.Tag&Constructed != 0 {
+		value = nil
+		for _, child := range p.Children {
+			value = append(value, child.Bytes()...)
+		}
+	}
+
+	out := []byte{p.Tag}
+	n := len(value)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, value...)
+}
+
+// Read decodes one element from r
+func Read(r *bufio.Reader) (*Packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// Running out of data after the tag means the element is truncated
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported length encoding")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxLength {
+		return nil, fmt.Errorf("element too large")
+	}
+
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return parse(tag, value)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parse builds the element with tag from its encoded value
+func parse(tag byte, value []byte) (*Packet, error) {
+	p := &Packet{Tag: tag}
+	if tag&Constructed == 0 {
+		p.Value = value
+		return p, nil
+	}
+
+	r := bufio.NewReader(bytes.NewReader(value))
+	for {
+		child, err := Read(r)
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed element: %v", err)
+		}
+		p.Children = append(p.Children, child)
+	}
+}
@@ -0,0 +1,117 @@
+package ber
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIntegerRoundTrip(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 31, -1 << 40} {
+		p := Integer(TagInteger, v)
+		got, err := Read(bufio.NewReader(bytes.NewReader(p.Bytes())))
+		if err != nil {
+			t.Fatalf("Read(%d): %v", v, err)
+		}
+		if got.Int() != v {
+			t.Errorf("round trip of %d gave %d", v, got.Int())
+		}
+	}
+}
+
+func TestIntegerEncoding(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{-1, []byte{0x02, 0x01, 0xff}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+	}
+	for _, tt := range tests {
+		if got := Integer(TagInteger, tt.v).Bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("Integer(%d) = % x, want % x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestSequenceRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	p := Sequence(TagSequence,
+		Integer(TagInteger, 7),
+		Sequence(ClassApplication|0,
+			OctetString(TagOctetString, "cn=test"),
+			OctetString(ClassContext|0, long),
+		),
+		Boolean(TagBoolean, true),
+		Sequence(TagSet),
+	)
+
+	got, err := Read(bufio.NewReader(bytes.NewReader(p.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Bytes(), p.Bytes()) {
+		t.Fatal("re-encoding differs")
+	}
+
+	if got.Tag != TagSequence || len(got.Children) != 4 {
+		t.Fatalf("got tag %#x with %d children", got.Tag, len(got.Children))
+	}
+	op := got.Child(1)
+	if op.Tag != ClassApplication|Constructed {
+		t.Errorf("got tag %#x", op.Tag)
+	}
+	if op.Child(0).String() != "cn=test" || op.Child(1).String() != long {
+		t.Errorf("octet strings did not round trip")
+	}
+	if got.Child(2).Value[0] != 0xff {
+		t.Errorf("boolean did not round trip")
+	}
+	if len(got.Child(3).Children) != 0 {
+		t.Errorf("empty set has children")
+	}
+}
+
+func TestChildOutOfRange(t *testing.T) {
+	p := Sequence(TagSequence)
+	if c := p.Child(3); c == nil || c.Int() != 0 || c.String() != "" {
+		t.Errorf("missing child did not decode as a zero value")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	valid := Sequence(TagSequence, OctetString(TagOctetString, "hello")).Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, io.EOF},
+		{"missing length", valid[:1], io.ErrUnexpectedEOF},
+		{"truncated value", valid[:len(valid)-1], io.ErrUnexpectedEOF},
+		{"truncated long length", []byte{0x04, 0x82, 0x01}, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		_, err := Read(bufio.NewReader(bytes.NewReader(tt.data)))
+		if err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	for name, data := range map[string][]byte{
+		"indefinite length": {0x30, 0x80, 0x00, 0x00},
+		"too large":         {0x04, 0x84, 0x7f, 0xff, 0xff, 0xff},
+		"bad child":         {0x30, 0x02, 0x04, 0x05},
+	} {
+		if _, err := Read(bufio.NewReader(bytes.NewReader(data))); err == nil {
+			t.Errorf("%s: Read succeeded", name)
+		}
+	}
+}
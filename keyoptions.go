@@ -0,0 +1,244 @@
+package sshserver
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Extensions recording authorized_keys options enforced after authentication.
+// Multiple values are separated by newlines.
+const (
+	permitOpenExtension   = "permitopen"
+	permitListenExtension = "permitlisten"
+	environmentExtension  = "environment"
+)
+
+// keyOptionPermits maps the authorized_keys options that grant or deny
+// features to the permit-* extensions they control
+var keyOptionPermits = map[string]string{
+	"pty":              permitPty,
+	"port-forwarding":  permitPortForwarding,
+	"agent-forwarding": permitAgentForwarding,
+}
+
+// keyOptionsPermissions turns the options of an authorized_keys entry into
+// ssh.Permissions. It fails if the options forbid the key for the client at
+// remote, if the key has expired, or if an option is not supported.
+func keyOptionsPermissions(options []string, remote net.Addr, now time.Time) (*ssh.Permissions, error) {
+	perms := &ssh.Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      make(map[string]string),
+	}
+
+	restrict := false
+	permits := make(map[string]bool)
+	for _, ext := range keyOptionPermits {
+		permits[ext] = true
+	}
+	var permitOpen, permitListen, environment []string
+
+	for _, option := range options {
+		name, value, hasValue := strings.Cut(option, "=")
+		name = strings.ToLower(name)
+		if hasValue {
+			var err error
+			if value, err = unquoteKeyOption(value); err != nil {
+				return nil, fmt.Errorf("invalid %s option: %v", name, err)
+			}
+		}
+
+		switch {
+		case name == "restrict":
+			restrict = true
+			for ext := range permits {
+				permits[ext] = false
+			}
+		case keyOptionPermits[name] != "":
+			permits[keyOptionPermits[name]] = true
+		case strings.HasPrefix(name, "no-") && keyOptionPermits[name[3:]] != "":
+			restrict = true
+			permits[keyOptionPermits[name[3:]]] = false
+		case name == "no-x11-forwarding" || name == "no-user-rc" || name == "x11-forwarding" || name == "user-rc":
+			// X11 forwarding and user rc files are never supported
+		case name == "command" && hasValue:
+			perms.CriticalOptions[forceCommandOption] = value
+		case name == "from" && hasValue:
+			if err := matchFromPatterns(remote, value); err != nil {
+				return nil, err
+			}
+		case name == "expiry-time" && hasValue:
+			expiry, err := parseExpiryTime(value)
+			if err != nil {
+				return nil, err
+			}
+			if !now.Before(expiry) {
+				return nil, fmt.Errorf("key expired at %s", expiry.Format(time.RFC3339))
+			}
+		case name == "permitopen" && hasValue:
+			if _, _, err := splitPermitSpec(value, false); err != nil {
+				return nil, err
+			}
+			permitOpen = append(permitOpen, value)
+		case name == "permitlisten" && hasValue:
+			if _, _, err := splitPermitSpec(value, true); err != nil {
+				return nil, err
+			}
+			permitListen = append(permitListen, value)
+		case name == "environment" && hasValue:
+			if k, _, ok := strings.Cut(value, "="); !ok || k == "" {
+				return nil, fmt.Errorf("invalid environment option %q", value)
+			}
+			environment = append(environment, value)
+		default:
+			return nil, fmt.Errorf("unsupported option %q", option)
+		}
+	}
+
+	if restrict {
+		perms.Extensions[restrictExtension] = ""
+		for ext, ok := range permits {
+			if ok {
+				perms.Extensions[ext] = ""
+			}
+		}
+	}
+	if len(permitOpen) > 0 {
+		perms.Extensions[permitOpenExtension] = strings.Join(permitOpen, "\n")
+	}
+	if len(permitListen) > 0 {
+		perms.Extensions[permitListenExtension] = strings.Join(permitListen, "\n")
+	}
+	if len(environment) > 0 {
+		perms.Extensions[environmentExtension] = strings.Join(environment, "\n")
+	}
+
+	return perms, nil
+}
+
+// unquoteKeyOption strips the quotes around an option value, unescaping
+// embedded quotes
+func unquoteKeyOption(value string) (string, error) {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", fmt.Errorf("value must be quoted")
+	}
+	return strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`), nil
+}
+
+// matchFromPatterns checks the client address against a from= list of
+// address patterns (with * and ? wildcards) and CIDR ranges. Entries starting
+// with '!' deny matching addresses.
+func matchFromPatterns(remote net.Addr, list string) error {
+	host, _, err := net.SplitHostPort(remote.String())
+	if err != nil {
+		return fmt.Errorf("cannot check source address of %s", remote)
+	}
+	ip := net.ParseIP(host)
+
+	allowed := false
+	for _, entry := range strings.Split(list, ",") {
+		pattern, negated := strings.CutPrefix(entry, "!")
+
+		var match bool
+		if strings.Contains(pattern, "/") {
+			_, ipNet, err := net.ParseCIDR(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid from pattern %q: %v", entry, err)
+			}
+			match = ip != nil && ipNet.Contains(ip)
+		} else if match, err = path.Match(strings.ToLower(pattern), host); err != nil {
+			return fmt.Errorf("invalid from pattern %q: %v", entry, err)
+		}
+
+		if match && negated {
+			return fmt.Errorf("source address %s is denied", host)
+		}
+		allowed = allowed || match
+	}
+
+	if !allowed {
+		return fmt.Errorf("source address %s is not allowed", host)
+	}
+	return nil
+}
+
+// parseExpiryTime parses an expiry-time value, YYYYMMDD[HHMM[SS]] in local
+// time or in UTC with a trailing 'Z'
+func parseExpiryTime(value string) (time.Time, error) {
+	loc := time.Local
+	if v, ok := strings.CutSuffix(value, "Z"); ok {
+		value, loc = v, time.UTC
+	}
+
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(value) == len(layout) {
+			if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry time %q", value)
+}
+
+// splitPermitSpec splits a permitopen "host:port" or permitlisten
+// "[host:]port" value. Either part may be "*".
+func splitPermitSpec(spec string, listen bool) (string, string, error) {
+	host, port := "*", spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		host, port = strings.Trim(spec[:i], "[]"), spec[i+1:]
+	} else if !listen {
+		return "", "", fmt.Errorf("invalid permitopen %q: missing port", spec)
+	}
+
+	if port != "*" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", "", fmt.Errorf("invalid port in %q", spec)
+		}
+	}
+	return host, port, nil
+}
+
+// forwardPermitted reports whether host and port match the permitopen or
+// permitlisten entries recorded under extension. Without entries every
+// destination is permitted.
+func forwardPermitted(perms *ssh.Permissions, extension, host string, port uint32) bool {
+	if perms == nil {
+		return true
+	}
+	specs, ok := perms.Extensions[extension]
+	if !ok {
+		return true
+	}
+
+	for _, spec := range strings.Split(specs, "\n") {
+		specHost, specPort, err := splitPermitSpec(spec, extension == permitListenExtension)
+		if err != nil {
+			continue
+		}
+		if specPort != "*" && specPort != strconv.FormatUint(uint64(port), 10) {
+			continue
+		}
+		if match, _ := path.Match(strings.ToLower(specHost), strings.ToLower(host)); match {
+			return true
+		}
+	}
+	return false
+}
+
+// keyEnvironment returns the variables set by environment= options
+func keyEnvironment(extensions map[string]string) map[string]string {
+	env := make(map[string]string)
+	if list, ok := extensions[environmentExtension]; ok {
+		for _, entry := range strings.Split(list, "\n") {
+			if name, value, ok := strings.Cut(entry, "="); ok {
+				env[name] = value
+			}
+		}
+	}
+	return env
+}
@@ -0,0 +1,138 @@
+package sshserver
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeyOptionsPermissions(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		options    []string
+		critical   map[string]string
+		extensions map[string]string
+	}{
+		{nil, map[string]string{}, map[string]string{}},
+		{
+			[]string{`command="echo \"hi\""`, `environment="LANG=C"`, `environment="TZ=UTC"`},
+			map[string]string{forceCommandOption: `echo "hi"`},
+			map[string]string{environmentExtension: "LANG=C\nTZ=UTC"},
+		},
+		{
+			[]string{"restrict", "pty"},
+			map[string]string{},
+			map[string]string{restrictExtension: "", permitPty: ""},
+		},
+		{
+			[]string{"no-agent-forwarding", "no-x11-forwarding"},
+			map[string]string{},
+			map[string]string{restrictExtension: "", permitPty: "", permitPortForwarding: ""},
+		},
+		{
+			[]string{"RESTRICT", `permitopen="db:5432"`, `permitopen="*:80"`, `permitlisten="8080"`},
+			map[string]string{},
+			map[string]string{
+				restrictExtension:     "",
+				permitOpenExtension:   "db:5432\n*:80",
+				permitListenExtension: "8080",
+			},
+		},
+		{
+			[]string{`from="10.0.0.0/8,192.0.2.*"`, `expiry-time="20240601120001Z"`},
+			map[string]string{},
+			map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		perms, err := keyOptionsPermissions(tt.options, remote, now)
+		if err != nil {
+			t.Errorf("%q: %v", tt.options, err)
+			continue
+		}
+		if !reflect.DeepEqual(perms.CriticalOptions, tt.critical) || !reflect.DeepEqual(perms.Extensions, tt.extensions) {
+			t.Errorf("%q: got %v %v, want %v %v", tt.options, perms.CriticalOptions, perms.Extensions, tt.critical, tt.extensions)
+		}
+	}
+
+	for _, options := range [][]string{
+		{`from="10.0.0.0/8"`},
+		{`from="192.0.2.0/24,!192.0.2.10"`},
+		{`from="10.0.0.0/33"`},
+		{`expiry-time="20240601"`},
+		{`expiry-time="2024-06-01"`},
+		{`command=echo`},
+		{`permitopen="db"`},
+		{`permitopen="db:http"`},
+		{`environment="=x"`},
+		{"tunnel=\"0\""},
+		{"cert-authority"},
+	} {
+		if _, err := keyOptionsPermissions(options, remote, now); err == nil {
+			t.Errorf("%q accepted", options)
+		}
+	}
+}
+
+func TestParseExpiryTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"20240601Z", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"202406011230Z", time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)},
+		{"20240601123045Z", time.Date(2024, 6, 1, 12, 30, 45, 0, time.UTC)},
+		{"20240601", time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseExpiryTime(tt.value)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseExpiryTime(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"", "2024", "20241301", "2024060112", "20240601Z0"} {
+		if _, err := parseExpiryTime(value); err == nil {
+			t.Errorf("parseExpiryTime(%q) succeeded", value)
+		}
+	}
+}
+
+func TestForwardPermitted(t *testing.T) {
+	perms, err := keyOptionsPermissions([]string{`permitopen="db:5432"`, `permitopen="*.internal:*"`, `permitlisten="localhost:8080"`}, &net.TCPAddr{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		extension string
+		host      string
+		port      uint32
+		want      bool
+	}{
+		{permitOpenExtension, "db", 5432, true},
+		{permitOpenExtension, "DB", 5432, true},
+		{permitOpenExtension, "db", 5433, false},
+		{permitOpenExtension, "cache.internal", 6379, true},
+		{permitOpenExtension, "example.com", 443, false},
+		{permitListenExtension, "localhost", 8080, true},
+		{permitListenExtension, "0.0.0.0", 8080, false},
+	}
+	for _, tt := range tests {
+		if got := forwardPermitted(perms, tt.extension, tt.host, tt.port); got != tt.want {
+			t.Errorf("forwardPermitted(%s, %s:%d) = %v, want %v", tt.extension, tt.host, tt.port, got, tt.want)
+		}
+	}
+	if !forwardPermitted(nil, permitOpenExtension, "anything", 1) {
+		t.Error("nil permissions refused a forward")
+	}
+}
+
+func TestKeyEnvironment(t *testing.T) {
+	env := keyEnvironment(map[string]string{environmentExtension: "A=1\nB=x=y"})
+	if !reflect.DeepEqual(env, map[string]string{"A": "1", "B": "x=y"}) {
+		t.Errorf("got %v", env)
+	}
+}
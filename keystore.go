@@ -0,0 +1,286 @@
+package sshserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultKeyReloadInterval is how often FileKeyStore files are checked for
+// changes when Config.KeyReloadInterval is zero
+const defaultKeyReloadInterval = 5 * time.Second
+
+// AuthorizedKey is one entry of an authorized_keys file
+type AuthorizedKey struct {
+	Key     ssh.PublicKey
+	Comment string
+	// Options are the entry's authorized_keys options (e.g. `command="ls"`)
+	Options []string
+	// Owner is the account the key belongs to, if the store knows it
+	Owner string
+}
+
+// KeyStore looks up the public keys users may authenticate with
+type KeyStore interface {
+	// AuthorizedKeys returns the entries authorizing key for user, or none
+	// if the key is not authorized
+	AuthorizedKeys(user string, key ssh.PublicKey) ([]AuthorizedKey, error)
+}
+
+// FileKeyStore is a KeyStore holding authorized_keys files in memory, indexed
+// by key. Keys come from a shared authorized_keys file valid for every user
+// and from a directory of per-user files named <user>.pub, each only valid
+// for that user. Lines that cannot be parsed are logged and skipped. Call
+// Reload or Watch to pick up changes; a deleted file authorizes no keys.
+type FileKeyStore struct {
+	file   string
+	dir    string
+	logger *log.Logger
+
+	mu     sync.RWMutex
+	shared map[string][]AuthorizedKey
+	users  map[string]map[string][]AuthorizedKey
+	stamp  string
+}
+
+// NewFileKeyStore loads the shared authorized_keys file and the per-user key
+// directory; either may be empty. Skipped lines are reported to logger if it
+// is not nil.
+func NewFileKeyStore(file, dir string, logger *log.Logger) (*FileKeyStore, error) {
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+
+	k := &FileKeyStore{file: file, dir: dir, logger: logger}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// AuthorizedKeys implements KeyStore
+func (k *FileKeyStore) AuthorizedKeys(user string, key ssh.PublicKey) ([]AuthorizedKey, error) {
+	blob := string(key.Marshal())
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	entries := append([]AuthorizedKey(nil), k.shared[blob]...)
+	return append(entries, k.users[user][blob]...), nil
+}
+
+// Reload reads the key files again. A file or directory that does not exist
+// holds no keys, so deleting it revokes them. On other errors the previously
+// loaded keys are kept.
+func (k *FileKeyStore) Reload() error {
+	stamp, err := k.currentStamp()
+	if err != nil {
+		return err
+	}
+
+	shared := make(map[string][]AuthorizedKey)
+	if k.file != "" {
+		if _, err := os.Stat(k.file); os.IsNotExist(err) {
+			k.logger.Printf("Authorized keys file %s does not exist, no shared keys loaded", k.file)
+		} else if err := k.loadFile(k.file, "", shared); err != nil {
+			return err
+		}
+	}
+
+	users := make(map[string]map[string][]AuthorizedKey)
+	if k.dir != "" {
+		entries, err := os.ReadDir(k.dir)
+		if os.IsNotExist(err) {
+			k.logger.Printf("Authorized keys directory %s does not exist, no per-user keys loaded", k.dir)
+		} else if err != nil {
+			return fmt.Errorf("failed to read authorized keys directory: %v", err)
+		}
+		for _, entry := range entries {
+			user, ok := strings.CutSuffix(entry.Name(), ".pub")
+			if !ok || user == "" || entry.IsDir() {
+				continue
+			}
+			keys := make(map[string][]AuthorizedKey)
+			if err := k.loadFile(filepath.Join(k.dir, entry.Name()), user, keys); err != nil {
+				k.logger.Printf("Skipping keys of user %s: %v", user, err)
+				continue
+			}
+			users[user] = keys
+		}
+	}
+
+	k.mu.Lock()
+	k.shared, k.users, k.stamp = shared, users, stamp
+	k.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the key files whenever their modification times change,
+// checking every interval until stop is closed
+func (k *FileKeyStore) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		stamp, err := k.currentStamp()
+		k.mu.RLock()
+		changed := err == nil && stamp != k.stamp
+		k.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := k.Reload(); err != nil {
+			k.logger.Printf("Failed to reload authorized keys: %v", err)
+			continue
+		}
+		k.logger.Printf("Reloaded authorized keys")
+	}
+}
+
+// loadFile adds the keys of an authorized_keys file owned by owner to keys
+func (k *FileKeyStore) loadFile(path, owner string, keys map[string][]AuthorizedKey) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open authorized keys file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, comment, options, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			k.logger.Printf("Skipping invalid key on line %d of %s: %v", n, path, err)
+			continue
+		}
+		blob := string(key.Marshal())
+		keys[blob] = append(keys[blob], AuthorizedKey{Key: key, Comment: comment, Options: options, Owner: owner})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read authorized keys file: %v", err)
+	}
+
+	return nil
+}
+
+// currentStamp summarizes the modification times and sizes of the key files
+// so Watch can tell when they change
+func (k *FileKeyStore) currentStamp() (string, error) {
+	var b strings.Builder
+	if k.file != "" {
+		info, err := os.Stat(k.file)
+		switch {
+		case os.IsNotExist(err):
+			b.WriteString("missing;")
+		case err != nil:
+			return "", fmt.Errorf("failed to stat authorized keys file: %v", err)
+		default:
+			fmt.Fprintf(&b, "%d/%d;", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+
+	if k.dir != "" {
+		entries, err := os.ReadDir(k.dir)
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to read authorized keys directory: %v", err)
+		}
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".pub") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&b, "%s:%d/%d;", entry.Name(), info.ModTime().UnixNano(), info.Size())
+		}
+	}
+
+	return b.String(), nil
+}
+
+// watchKeys reloads the key store, trusted CA keys and revoked keys when
+// their files change, and on SIGHUP if Config.ReloadOnSIGHUP is set, until the
+// server stops
+func (s *Server) watchKeys() {
+	if s.config.KeyReloadInterval >= 0 {
+		interval := s.config.KeyReloadInterval
+		if interval == 0 {
+			interval = defaultKeyReloadInterval
+		}
+
+		if store, ok := s.keyStore.(*FileKeyStore); ok {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				store.Watch(interval, s.done)
+			}()
+		}
+		if s.trustFiles != nil {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.trustFiles.Watch(interval, s.done)
+			}()
+		}
+	}
+
+	if !s.config.ReloadOnSIGHUP {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-hup:
+			}
+
+			if reloader, ok := s.keyStore.(interface{ Reload() error }); ok {
+				if err := reloader.Reload(); err != nil {
+					s.logger.Printf("Failed to reload authorized keys: %v", err)
+				} else {
+					s.logger.Printf("Reloaded authorized keys on SIGHUP")
+				}
+			}
+			if s.trustFiles != nil {
+				if err := s.trustFiles.Reload(); err != nil {
+					s.logger.Printf("Failed to reload trusted CA and revoked keys: %v", err)
+				} else {
+					s.logger.Printf("Reloaded trusted CA and revoked keys on SIGHUP")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,119 @@
+package sshserver
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFileKeyStore(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "authorized_keys")
+	keysDir := filepath.Join(dir, "keys")
+	shared, alices := newTestPublicKey(t), newTestPublicKey(t)
+
+	data := "# shared keys\nnot a key\n" + `command="uptime" ` + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(shared))) + " ops key\n"
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(keysDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(keysDir, "alice.pub"), ssh.MarshalAuthorizedKey(alices), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	store, err := NewFileKeyStore(file, keysDir, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "Skipping invalid key on line 2") {
+		t.Errorf("invalid line not logged: %q", logs.String())
+	}
+
+	entries, _ := store.AuthorizedKeys("bob", shared)
+	if len(entries) != 1 || entries[0].Comment != "ops key" || entries[0].Owner != "" || len(entries[0].Options) != 1 {
+		t.Errorf("shared key for bob: got %+v", entries)
+	}
+	if entries, _ := store.AuthorizedKeys("alice", alices); len(entries) != 1 || entries[0].Owner != "alice" {
+		t.Errorf("alice's key: got %+v", entries)
+	}
+	if entries, _ := store.AuthorizedKeys("bob", alices); len(entries) != 0 {
+		t.Errorf("alice's key authorized bob")
+	}
+}
+
+func TestFileKeyStoreDeletedFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "authorized_keys")
+	key := newTestPublicKey(t)
+	if err := os.WriteFile(file, ssh.MarshalAuthorizedKey(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	store, err := NewFileKeyStore(file, "", log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatalf("reload after deletion failed: %v", err)
+	}
+	if entries, _ := store.AuthorizedKeys("alice", key); len(entries) != 0 {
+		t.Error("key of a deleted file is still authorized")
+	}
+	if !strings.Contains(logs.String(), "does not exist") {
+		t.Errorf("deletion not logged: %q", logs.String())
+	}
+}
+
+func TestFileKeyStoreWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "authorized_keys")
+	key := newTestPublicKey(t)
+	if err := os.WriteFile(file, ssh.MarshalAuthorizedKey(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileKeyStore(file, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go store.Watch(5*time.Millisecond, stop)
+
+	authorized := func() bool {
+		entries, _ := store.AuthorizedKeys("alice", key)
+		return len(entries) > 0
+	}
+	waitFor := func(want bool, what string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for authorized() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not picked up", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(false, "deleting the file")
+
+	if err := os.WriteFile(file, ssh.MarshalAuthorizedKey(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(true, "restoring the file")
+}
@@ -0,0 +1,344 @@
+package sshserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"repo.nusatek.id/sugeng/gosh/internal/ber"
+)
+
+// defaultLDAPTimeout bounds an LDAP exchange when LDAPAuthenticator.Timeout is
+// zero
+const defaultLDAPTimeout = 10 * time.Second
+
+// LDAP protocol operations and result codes
+const (
+	ldapBindRequest       = ber.ClassApplication | ber.Constructed | 0
+	ldapBindResponse      = ber.ClassApplication | ber.Constructed | 1
+	ldapUnbindRequest     = ber.ClassApplication | 2
+	ldapSearchRequest     = ber.ClassApplication | ber.Constructed | 3
+	ldapSearchResultEntry = ber.ClassApplication | ber.Constructed | 4
+	ldapSearchResultDone  = ber.ClassApplication | ber.Constructed | 5
+
+	ldapSimpleAuth    = ber.ClassContext | 0
+	ldapPresentFilter = ber.ClassContext | 7
+
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+// LDAPAuthenticator is an Authenticator checking passwords with an LDAP simple
+// bind as the user. After a successful bind it reads the user's entry for
+// group, role and extra attributes. Keyboard-interactive authentication asks
+// for the password; public keys are not supported.
+type LDAPAuthenticator struct {
+	// URL is the server address, "ldaps://host[:port]" or
+	// "ldap://host[:port]". Simple binds send the password as is, so with
+	// ldap:// it crosses the network in cleartext; StartTLS is not supported.
+	// Only use ldap:// for a server on the same host or a trusted network.
+	URL string
+	// UserDN is the DN template users bind as, with %s replaced by the
+	// escaped user name, e.g. "uid=%s,ou=people,dc=example,dc=com"
+	UserDN string
+	// GroupAttribute names the attribute listing the user's groups, e.g.
+	// "memberOf". DN values are reduced to their first RDN value, so
+	// "cn=ops,ou=groups,dc=example,dc=com" becomes "ops".
+	GroupAttribute string
+	// RoleAttribute names the attribute listing the user's roles
+	RoleAttribute string
+	// Attributes are copied into AuthResult.Attributes; multiple values are
+	// joined with commas
+	Attributes []string
+	// TLSConfig is used for ldaps:// URLs; nil uses the defaults
+	TLSConfig *tls.Config
+	// Timeout bounds each LDAP exchange; zero means 10 seconds
+	Timeout time.Duration
+}
+
+// PublicKey implements Authenticator; it is not supported
+func (l *LDAPAuthenticator) PublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*AuthResult, error) {
+	return nil, ErrUnsupportedMethod
+}
+
+// Password implements Authenticator
+func (l *LDAPAuthenticator) Password(conn ssh.ConnMetadata, password []byte) (*AuthResult, error) {
+	return l.authenticate(conn.User(), string(password))
+}
+
+// KeyboardInteractive implements Authenticator by prompting for the password
+func (l *LDAPAuthenticator) KeyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*AuthResult, error) {
+	answers, err := client("", "", []string{"Password: "}, []bool{false})
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != 1 {
+		return nil, fmt.Errorf("expected 1 answer, got %d", len(answers))
+	}
+	return l.authenticate(conn.User(), answers[0])
+}
+
+// authenticate binds as user and reads the entry's attributes
+func (l *LDAPAuthenticator) authenticate(user, password string) (*AuthResult, error) {
+	// An empty password would make an unauthenticated bind, which servers
+	// accept for any DN
+	if user == "" || password == "" {
+		return nil, ErrAccessDenied
+	}
+	dn := fmt.Sprintf(l.UserDN, escapeDNValue(user))
+
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if err := conn.bind(dn, password); err != nil {
+		return nil, err
+	}
+
+	var attrs []string
+	for _, attr := range append([]string{l.GroupAttribute, l.RoleAttribute}, l.Attributes...) {
+		if attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	result := &AuthResult{}
+	if len(attrs) == 0 {
+		return result, nil
+	}
+
+	entry, err := conn.readEntry(dn, attrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range entry[strings.ToLower(l.GroupAttribute)] {
+		result.Groups = append(result.Groups, rdnValue(group))
+	}
+	result.Roles = entry[strings.ToLower(l.RoleAttribute)]
+	for _, attr := range l.Attributes {
+		if values, ok := entry[strings.ToLower(attr)]; ok {
+			if result.Attributes == nil {
+				result.Attributes = make(map[string]string)
+			}
+			result.Attributes[attr] = strings.Join(values, ",")
+		}
+	}
+	return result, nil
+}
+
+// dial connects to the server in URL
+func (l *LDAPAuthenticator) dial() (*ldapConn, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %v", err)
+	}
+
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = defaultLDAPTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", hostWithPort(u, "389"))
+	case "ldaps":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostWithPort(u, "636"), l.TLSConfig)
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %v", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	return &ldapConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// hostWithPort returns the host of u, adding port if it has none
+func hostWithPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// ldapConn is a connection to an LDAP server exchanging one request at a
+// time
+type ldapConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	lastID int64
+}
+
+// send writes an LDAPMessage carrying op and returns its message ID
+func (c *ldapConn) send(op *ber.Packet) (int64, error) {
+	c.lastID++
+	msg := ber.Sequence(ber.TagSequence, ber.Integer(ber.TagInteger, c.lastID), op)
+	if _, err := c.conn.Write(msg.Bytes()); err != nil {
+		return 0, fmt.Errorf("failed to send LDAP request: %v", err)
+	}
+	return c.lastID, nil
+}
+
+// receive reads the protocol operation of the next response to request id
+func (c *ldapConn) receive(id int64) (*ber.Packet, error) {
+	for {
+		msg, err := ber.Read(c.r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP response: %v", err)
+		}
+		if msg.Tag != ber.TagSequence || len(msg.Children) < 2 {
+			return nil, fmt.Errorf("malformed LDAP response")
+		}
+		if msg.Child(0).Int() == id {
+			return msg.Child(1), nil
+		}
+	}
+}
+
+// bind performs a simple bind as dn
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(ber.Sequence(ldapBindRequest,
+		ber.Integer(ber.TagInteger, 3),
+		ber.OctetString(ber.TagOctetString, dn),
+		ber.OctetString(ldapSimpleAuth, password),
+	))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if resp.Tag != ldapBindResponse {
+		return fmt.Errorf("unexpected LDAP response to bind")
+	}
+	return ldapResultError(resp, "bind")
+}
+
+// readEntry reads attrs of the entry dn. The returned map is keyed by
+// lower-case attribute name.
+func (c *ldapConn) readEntry(dn string, attrs []string) (map[string][]string, error) {
+	attrList := ber.Sequence(ber.TagSequence)
+	for _, attr := range attrs {
+		attrList.Children = append(attrList.Children, ber.OctetString(ber.TagOctetString, attr))
+	}
+
+	id, err := c.send(ber.Sequence(ldapSearchRequest,
+		ber.OctetString(ber.TagOctetString, dn),
+		ber.Integer(ber.TagEnumerated, 0), // baseObject
+		ber.Integer(ber.TagEnumerated, 0), // neverDerefAliases
+		ber.Integer(ber.TagInteger, 1),
+		ber.Integer(ber.TagInteger, 0),
+		ber.Boolean(ber.TagBoolean, false),
+		ber.OctetString(ldapPresentFilter, "objectClass"),
+		attrList,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := make(map[string][]string)
+	for {
+		resp, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.Tag {
+		case ldapSearchResultEntry:
+			for _, attr := range resp.Child(1).Children {
+				name := strings.ToLower(attr.Child(0).String())
+				for _, value := range attr.Child(1).Children {
+					entry[name] = append(entry[name], value.String())
+				}
+			}
+		case ldapSearchResultDone:
+			if err := ldapResultError(resp, "search"); err != nil {
+				return nil, err
+			}
+			return entry, nil
+		}
+	}
+}
+
+// close unbinds and closes the connection
+func (c *ldapConn) close() {
+	c.send(&ber.Packet{Tag: ldapUnbindRequest})
+	c.conn.Close()
+}
+
+// ldapResultError converts the LDAPResult in resp to an error
+func ldapResultError(resp *ber.Packet, operation string) error {
+	switch code := resp.Child(0).Int(); code {
+	case ldapSuccess:
+		return nil
+	case ldapInvalidCredentials:
+		return ErrAccessDenied
+	default:
+		return fmt.Errorf("LDAP %s failed with result %d: %s", operation, code, resp.Child(2).String())
+	}
+}
+
+// escapeDNValue escapes s for use as an attribute value in a DN, as described
+// in RFC 4514
+func escapeDNValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			c == ' ' && (i == 0 || i == len(s)-1),
+			c == '#' && i == 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// rdnValue returns the value of the first RDN of dn, or dn itself if it is
+// not a DN
+func rdnValue(dn string) string {
+	_, rest, ok := strings.Cut(dn, "=")
+	if !ok {
+		return dn
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(rest); i++ {
+		switch c := rest[i]; {
+		case c == '\\' && i+2 < len(rest) && isHexDigit(rest[i+1]) && isHexDigit(rest[i+2]):
+			n, _ := strconv.ParseUint(rest[i+1:i+3], 16, 8)
+			b.WriteByte(byte(n))
+			i += 2
+		case c == '\\' && i+1 < len(rest):
+			i++
+			b.WriteByte(rest[i])
+		case c == ',' || c == '+':
+			return b.String()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
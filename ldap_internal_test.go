@@ -0,0 +1,50 @@
+package sshserver
+
+import "testing"
+
+func TestEscapeDNValue(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"alice", "alice"},
+		{"bob+x", `bob\+x`},
+		{"a,b=c", `a\,b\=c`},
+		{`"q"<>;\`, `\"q\"\<\>\;\\`},
+		{" lead", `\ lead`},
+		{"trail ", `trail\ `},
+		{"in side", "in side"},
+		{"#hash", `\#hash`},
+		{"a#b", "a#b"},
+		{"nul\x00", `nul\00`},
+		{"tab\t", `tab\09`},
+		{"José", "José"},
+	}
+	for _, tt := range tests {
+		if got := escapeDNValue(tt.in); got != tt.want {
+			t.Errorf("escapeDNValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRDNValue(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"cn=ops,ou=groups,dc=example,dc=com", "ops"},
+		{"cn=ops", "ops"},
+		{`cn=dev\, qa,ou=groups`, "dev, qa"},
+		{`cn=dev\2c qa,ou=groups`, "dev, qa"},
+		{`cn=Jos\c3\a9,ou=people`, "José"},
+		{"cn=a+uid=b,ou=people", "a"},
+		{"ops", "ops"},
+		{`cn=trailing\`, "trailing\\"},
+	}
+	for _, tt := range tests {
+		if got := rdnValue(tt.in); got != tt.want {
+			t.Errorf("rdnValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// Escaping and parsing are inverses
+	for _, s := range []string{"bob+x", " a,b ", "#1", "x\x01y"} {
+		if got := rdnValue("cn=" + escapeDNValue(s)); got != s {
+			t.Errorf("rdnValue(escapeDNValue(%q)) = %q", s, got)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package sshserver_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sshserver "repo.nusatek.id/sugeng/gosh"
+	"repo.nusatek.id/sugeng/gosh/authtest"
+)
+
+func newTestLDAP(t *testing.T) (*authtest.LDAPServer, *sshserver.LDAPAuthenticator) {
+	t.Helper()
+	server := authtest.NewLDAPServer()
+	t.Cleanup(server.Close)
+
+	server.AddEntry("uid=alice,ou=people,dc=example,dc=com", "secret", map[string][]string{
+		"memberOf":     {"cn=ops,ou=groups,dc=example,dc=com", `cn=dev\2c qa,ou=groups,dc=example,dc=com`},
+		"employeeType": {"admin"},
+		"cn":           {"Alice Liddell"},
+		"mail":         {"alice@example.com", "a@example.com"},
+	})
+	server.AddEntry(`uid=bob\+x,ou=people,dc=example,dc=com`, "hunter2", nil)
+
+	auth := &sshserver.LDAPAuthenticator{
+		URL:            server.URL,
+		UserDN:         "uid=%s,ou=people,dc=example,dc=com",
+		GroupAttribute: "memberOf",
+		RoleAttribute:  "employeeType",
+		Attributes:     []string{"cn", "mail", "telephoneNumber"},
+	}
+	return server, auth
+}
+
+func TestLDAPPassword(t *testing.T) {
+	_, auth := newTestLDAP(t)
+
+	result, err := auth.Password(sshserver.NewTestConn("alice"), []byte("secret"))
+	if err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+
+	want := &sshserver.AuthResult{
+		Groups: []string{"ops", "dev, qa"},
+		Roles:  []string{"admin"},
+		Attributes: map[string]string{
+			"cn":   "Alice Liddell",
+			"mail": "alice@example.com,a@example.com",
+		},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+}
+
+func TestLDAPInvalidCredentials(t *testing.T) {
+	_, auth := newTestLDAP(t)
+
+	for _, tt := range []struct{ user, password string }{
+		{"alice", "wrong"},
+		{"mallory", "secret"},
+	} {
+		if _, err := auth.Password(sshserver.NewTestConn(tt.user), []byte(tt.password)); err != sshserver.ErrAccessDenied {
+			t.Errorf("%s/%s: got %v, want ErrAccessDenied", tt.user, tt.password, err)
+		}
+	}
+}
+
+func TestLDAPEmptyPassword(t *testing.T) {
+	server, auth := newTestLDAP(t)
+
+	if _, err := auth.Password(sshserver.NewTestConn("alice"), nil); err != sshserver.ErrAccessDenied {
+		t.Errorf("got %v, want ErrAccessDenied", err)
+	}
+	if server.Binds() != 0 {
+		t.Errorf("empty password reached the server as an unauthenticated bind")
+	}
+}
+
+func TestLDAPEscapesUserName(t *testing.T) {
+	_, auth := newTestLDAP(t)
+
+	// "bob+x" is only found if '+' is escaped in the DN
+	result, err := auth.Password(sshserver.NewTestConn("bob+x"), []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("bind failed: %v", err)
+	}
+	if len(result.Groups) != 0 || len(result.Roles) != 0 || result.Attributes != nil {
+		t.Errorf("got %+v for an entry without attributes", result)
+	}
+
+	// An injected RDN must not reach alice's entry
+	if _, err := auth.Password(sshserver.NewTestConn("alice,ou=people,dc=example,dc=com"), []byte("secret")); err != sshserver.ErrAccessDenied {
+		t.Errorf("got %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestLDAPKeyboardInteractive(t *testing.T) {
+	_, auth := newTestLDAP(t)
+
+	var prompts []string
+	client := func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		prompts = append(prompts, questions...)
+		return []string{"secret"}, nil
+	}
+	result, err := auth.KeyboardInteractive(sshserver.NewTestConn("alice"), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prompts, []string{"Password: "}) {
+		t.Errorf("got prompts %q", prompts)
+	}
+	if !reflect.DeepEqual(result.Roles, []string{"admin"}) {
+		t.Errorf("got roles %q", result.Roles)
+	}
+}
+
+func TestLDAPPublicKeyUnsupported(t *testing.T) {
+	_, auth := newTestLDAP(t)
+
+	if _, err := auth.PublicKey(sshserver.NewTestConn("alice"), nil); err != sshserver.ErrUnsupportedMethod {
+		t.Errorf("got %v, want ErrUnsupportedMethod", err)
+	}
+}
+
+func TestLDAPUnreachable(t *testing.T) {
+	server, auth := newTestLDAP(t)
+	server.Close()
+
+	_, err := auth.Password(sshserver.NewTestConn("alice"), []byte("secret"))
+	if err == nil || err == sshserver.ErrAccessDenied {
+		t.Errorf("got %v, want a connection error", err)
+	}
+}
+
+func TestLDAPCaching(t *testing.T) {
+	server, auth := newTestLDAP(t)
+	cached := sshserver.NewCachingAuthenticator(auth, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := cached.Password(sshserver.NewTestConn("alice"), []byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if server.Binds() != 1 {
+		t.Errorf("got %d binds, want 1", server.Binds())
+	}
+
+	if _, err := cached.Password(sshserver.NewTestConn("alice"), []byte("other")); err != sshserver.ErrAccessDenied {
+		t.Errorf("got %v, want ErrAccessDenied", err)
+	}
+	if server.Binds() != 2 {
+		t.Errorf("a different password was answered from the cache")
+	}
+}
@@ -0,0 +1,646 @@
+package sshserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Special keys decoded from escape sequences. They live in the surrogate
+// range, which utf8.DecodeRune never returns, so they can't clash with input.
+const (
+	keyUnknown rune = 0xd800 + iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	keyDeleteWordBack
+)
+
+// Control characters handled by the line editor
+const (
+	keyCtrlA     = 0x01
+	keyCtrlB     = 0x02
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyCtrlF     = 0x06
+	keyCtrlG     = 0x07
+	keyCtrlH     = 0x08
+	keyTab       = 0x09
+	keyCtrlK     = 0x0b
+	keyCtrlL     = 0x0c
+	keyCtrlN     = 0x0e
+	keyCtrlP     = 0x10
+	keyCtrlR     = 0x12
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyCtrlY     = 0x19
+	keyEscape    = 0x1b
+	keyBackspace = 0x7f
+)
+
+// defaultTerminalWidth is assumed when the client didn't report its size
+const defaultTerminalWidth = 80
+
+// lineEditor reads lines from an interactive terminal, echoing input and
+// supporting cursor movement, in-line editing and history recall with the
+// usual emacs-style key bindings
+type lineEditor struct {
+	in  io.Reader
+	out io.Writer
+
+	// width is the terminal width used to track wrapped lines. It is updated
+	// from the request goroutine when the client resizes its window.
+	width atomic.Int32
+
+	prompt    string
+	line      []rune
+	pos       int
+	cursorRow int
+
+	// killed is the text last deleted by Ctrl-K, Ctrl-U or Ctrl-W, which
+	// Ctrl-Y inserts again
+	killed []rune
+
+	// pending holds input that has been read but not yet processed
+	pending []byte
+	lastCR  bool
+
+	history    *history
+	historyIdx int
+	draft      []rune
+
+	// completer offers Tab completions; lastTab records whether the previous
+	// key was Tab so a second press can list ambiguous candidates
+	completer Completer
+	lastTab   bool
+
+	// Reverse incremental search (Ctrl-R) state
+	searching   bool
+	searchQuery []rune
+	searchIdx   int
+	searchFail  bool
+	savedPrompt string
+	savedLine   []rune
+}
+
+// newLineEditor creates a line editor reading keys from in and echoing to out
+func newLineEditor(in io.Reader, out io.Writer) *lineEditor {
+	e := &lineEditor{
+		in:      in,
+		out:     out,
+		history: newHistory(),
+	}
+	e.setWidth(defaultTerminalWidth)
+	return e
+}
+
+// setWidth updates the terminal width used for line wrapping
+func (e *lineEditor) setWidth(width int) {
+	if width <= 0 {
+		width = defaultTerminalWidth
+	}
+	e.width.Store(int32(width))
+}
+
+// readLine shows prompt and returns the next line entered by the user
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	e.prompt = prompt
+	e.line = e.line[:0]
+	e.pos = 0
+	e.cursorRow = 0
+	e.historyIdx = len(e.history.entries)
+	e.draft = nil
+	e.searching = false
+	e.out.Write([]byte(prompt))
+
+	buf := make([]byte, 256)
+	for {
+		for len(e.pending) > 0 {
+			key, n := parseKey(e.pending)
+			if n == 0 {
+				break
+			}
+			e.pending = e.pending[n:]
+
+			if line, done, err := e.handleKey(key); done {
+				return line, err
+			}
+		}
+
+		n, err := e.in.Read(buf)
+		if err != nil {
+			return "", err
+		}
+		e.pending = append(e.pending, buf[:n]...)
+	}
+}
+
+// takePending returns input that arrived after the last line so it can be
+// passed on to the command that line runs
+func (e *lineEditor) takePending() []byte {
+	pending := e.pending
+	e.pending = nil
+	return pending
+}
+
+// handleKey applies a single key press and reports whether reading the line is
+// done. Reading ends with errInterrupt on Ctrl-C and io.EOF on Ctrl-D when the
+// line is empty.
+func (e *lineEditor) handleKey(key rune) (string, bool, error) {
+	if key == '\n' && e.lastCR {
+		// Treat CR LF as a single Enter
+		e.lastCR = false
+		return "", false, nil
+	}
+	e.lastCR = key == '\r'
+
+	if key == keyCtrlC {
+		if e.searching {
+			e.endSearch()
+		}
+		e.moveTo(len(e.line))
+		e.out.Write([]byte("^C\r\n"))
+		return "", true, errInterrupt
+	}
+
+	if e.searching && e.handleSearchKey(key) {
+		return "", false, nil
+	}
+
+	wasTab := e.lastTab
+	e.lastTab = key == keyTab
+
+	switch key {
+	case '\r', '\n':
+		e.moveTo(len(e.line))
+		e.out.Write([]byte("\r\n"))
+		return string(e.line), true, nil
+	case keyBackspace, keyCtrlH:
+		if e.pos > 0 {
+			e.line = append(e.line[:e.pos-1], e.line[e.pos:]...)
+			e.pos--
+			e.refresh()
+		}
+	case keyCtrlD:
+		if len(e.line) == 0 {
+			e.out.Write([]byte("\r\n"))
+			return "", true, io.EOF
+		}
+		if e.pos < len(e.line) {
+			e.line = append(e.line[:e.pos], e.line[e.pos+1:]...)
+			e.refresh()
+		}
+	case keyDelete:
+		if e.pos < len(e.line) {
+			e.line = append(e.line[:e.pos], e.line[e.pos+1:]...)
+			e.refresh()
+		}
+	case keyLeft, keyCtrlB:
+		if e.pos > 0 {
+			e.moveTo(e.pos - 1)
+		}
+	case keyRight, keyCtrlF:
+		if e.pos < len(e.line) {
+			e.moveTo(e.pos + 1)
+		}
+	case keyUp, keyCtrlP:
+		e.recall(e.historyIdx - 1)
+	case keyDown, keyCtrlN:
+		e.recall(e.historyIdx + 1)
+	case keyCtrlR:
+		e.startSearch()
+	case keyTab:
+		e.complete(wasTab)
+	case keyHome, keyCtrlA:
+		e.moveTo(0)
+	case keyEnd, keyCtrlE:
+		e.moveTo(len(e.line))
+	case keyWordLeft:
+		e.moveTo(e.wordStart())
+	case keyWordRight:
+		e.moveTo(e.wordEnd())
+	case keyCtrlK:
+		e.kill(e.pos, len(e.line))
+	case keyCtrlU:
+		e.kill(0, e.pos)
+	case keyCtrlW, keyDeleteWordBack:
+		e.kill(e.wordStart(), e.pos)
+	case keyCtrlY:
+		if len(e.killed) > 0 {
+			e.insert(append([]rune(nil), e.killed...))
+		}
+	case keyCtrlL:
+		e.out.Write([]byte("\x1b[H\x1b[2J"))
+		e.cursorRow = 0
+		e.refresh()
+	default:
+		if isPrintable(key) {
+			e.insert([]rune{key})
+		}
+	}
+
+	return "", false, nil
+}
+
+// recall replaces the line with history entry idx; idx equal to the number of
+// entries restores the line that was being typed before browsing history
+func (e *lineEditor) recall(idx int) {
+	if idx < 0 || idx > len(e.history.entries) || idx == e.historyIdx {
+		return
+	}
+
+	if e.historyIdx == len(e.history.entries) {
+		e.draft = append([]rune(nil), e.line...)
+	}
+
+	e.historyIdx = idx
+	if idx == len(e.history.entries) {
+		e.line = append(e.line[:0], e.draft...)
+	} else {
+		e.line = []rune(e.history.entries[idx])
+	}
+	e.pos = len(e.line)
+	e.refresh()
+}
+
+// complete asks the completer for candidates for the word before the cursor.
+// A single candidate replaces the word; several extend it to their common
+// prefix, and a second Tab lists them.
+func (e *lineEditor) complete(list bool) {
+	if e.completer == nil {
+		return
+	}
+
+	line := string(e.line)
+	pos := len(string(e.line[:e.pos]))
+	start := wordBefore(line, pos)
+
+	candidates := e.completer.Complete(line, pos)
+	if len(candidates) == 0 {
+		return
+	}
+
+	replacement := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(replacement, "/") {
+		replacement += " "
+	}
+
+	if replacement != line[start:pos] {
+		e.line = []rune(line[:start] + replacement + line[pos:])
+		e.pos = utf8.RuneCountInString(line[:start] + replacement)
+		e.refresh()
+		return
+	}
+
+	if list && len(candidates) > 1 {
+		e.moveTo(len(e.line))
+		e.out.Write([]byte("\r\n" + formatColumns(candidates, int(e.width.Load()))))
+		e.cursorRow = 0
+		e.refresh()
+	}
+}
+
+// startSearch enters reverse incremental search mode
+func (e *lineEditor) startSearch() {
+	e.searching = true
+	e.searchQuery = e.searchQuery[:0]
+	e.searchIdx = len(e.history.entries)
+	e.searchFail = false
+	e.savedPrompt = e.prompt
+	e.savedLine = append([]rune(nil), e.line...)
+	e.refreshSearch()
+}
+
+// handleSearchKey processes a key in search mode and reports whether it was
+// consumed. Keys that aren't search commands end the search, keeping the
+// match, and are then handled as regular editing keys.
+func (e *lineEditor) handleSearchKey(key rune) bool {
+	switch key {
+	case keyCtrlR:
+		e.search(e.searchIdx - 1)
+	case keyBackspace, keyCtrlH:
+		if len(e.searchQuery) > 0 {
+			e.searchQuery = e.searchQuery[:len(e.searchQuery)-1]
+			e.search(len(e.history.entries) - 1)
+		}
+	case keyCtrlG:
+		e.line = append(e.line[:0], e.savedLine...)
+		e.pos = len(e.line)
+		e.endSearch()
+	default:
+		if !isPrintable(key) {
+			e.endSearch()
+			return false
+		}
+		e.searchQuery = append(e.searchQuery, key)
+		start := e.searchIdx
+		if start >= len(e.history.entries) {
+			start = len(e.history.entries) - 1
+		}
+		e.search(start)
+	}
+
+	return true
+}
+
+// search finds the newest history entry at or before index from that contains
+// the search query and shows it
+func (e *lineEditor) search(from int) {
+	query := string(e.searchQuery)
+	e.searchFail = true
+
+	for i := from; i >= 0 && i < len(e.history.entries); i-- {
+		entry := e.history.entries[i]
+		if idx := strings.Index(entry, query); idx >= 0 {
+			e.searchIdx = i
+			e.searchFail = false
+			e.line = []rune(entry)
+			e.pos = utf8.RuneCountInString(entry[:idx])
+			break
+		}
+	}
+
+	e.refreshSearch()
+}
+
+// endSearch leaves search mode and restores the regular prompt
+func (e *lineEditor) endSearch() {
+	e.searching = false
+	e.prompt = e.savedPrompt
+	e.historyIdx = len(e.history.entries)
+	e.refresh()
+}
+
+// refreshSearch redraws the line with the search status in place of the prompt
+func (e *lineEditor) refreshSearch() {
+	status := "reverse-i-search"
+	if e.searchFail {
+		status = "failed " + status
+	}
+	e.prompt = fmt.Sprintf("(%s)`%s': ", status, string(e.searchQuery))
+	e.refresh()
+}
+
+// insert adds runes at the cursor position
+func (e *lineEditor) insert(runes []rune) {
+	atEnd := e.pos == len(e.line)
+	e.line = append(e.line[:e.pos], append(runes, e.line[e.pos:]...)...)
+	e.pos += len(runes)
+
+	col, _ := e.position(e.pos)
+	if atEnd && col != 0 {
+		// Fast path: plain typing at the end of the line only needs an echo
+		e.out.Write([]byte(string(runes)))
+		return
+	}
+	e.refresh()
+}
+
+// kill deletes line[start:end] and keeps it for Ctrl-Y
+func (e *lineEditor) kill(start, end int) {
+	if start == end {
+		return
+	}
+	e.killed = append(e.killed[:0], e.line[start:end]...)
+	e.line = append(e.line[:start], e.line[end:]...)
+	e.pos = start
+	e.refresh()
+}
+
+// wordStart returns the position of the start of the word before the cursor
+func (e *lineEditor) wordStart() int {
+	pos := e.pos
+	for pos > 0 && unicode.IsSpace(e.line[pos-1]) {
+		pos--
+	}
+	for pos > 0 && !unicode.IsSpace(e.line[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+// wordEnd returns the position of the end of the word after the cursor
+func (e *lineEditor) wordEnd() int {
+	pos := e.pos
+	for pos < len(e.line) && unicode.IsSpace(e.line[pos]) {
+		pos++
+	}
+	for pos < len(e.line) && !unicode.IsSpace(e.line[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// position returns the terminal column and row, relative to the start of the
+// prompt, at which the rune at index i of the line is displayed
+func (e *lineEditor) position(i int) (int, int) {
+	total := stringWidth(e.prompt) + runesWidth(e.line[:i])
+	width := int(e.width.Load())
+	return total % width, total / width
+}
+
+// moveTo moves the cursor to index pos of the line without redrawing it
+func (e *lineEditor) moveTo(pos int) {
+	var b bytes.Buffer
+	col, row := e.position(pos)
+	e.moveCursor(&b, col, row)
+	e.pos = pos
+	e.out.Write(b.Bytes())
+}
+
+// moveCursor appends the escape sequences that move the cursor from its
+// current row to col and row
+func (e *lineEditor) moveCursor(b *bytes.Buffer, col, row int) {
+	if row < e.cursorRow {
+		fmt.Fprintf(b, "\x1b[%dA", e.cursorRow-row)
+	} else if row > e.cursorRow {
+		fmt.Fprintf(b, "\x1b[%dB", row-e.cursorRow)
+	}
+	b.WriteByte('\r')
+	if col > 0 {
+		fmt.Fprintf(b, "\x1b[%dC", col)
+	}
+	e.cursorRow = row
+}
+
+// refresh redraws the prompt and line and places the cursor at e.pos
+func (e *lineEditor) refresh() {
+	var b bytes.Buffer
+
+	e.moveCursor(&b, 0, 0)
+	b.WriteString(e.prompt)
+	b.WriteString(string(e.line))
+
+	endCol, endRow := e.position(len(e.line))
+	if endCol == 0 && endRow > 0 {
+		// The terminal leaves the cursor on the last column after filling a
+		// row; force the wrap so our row tracking stays correct
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\x1b[J")
+	e.cursorRow = endRow
+
+	col, row := e.position(e.pos)
+	e.moveCursor(&b, col, row)
+	e.out.Write(b.Bytes())
+}
+
+// parseKey decodes the first key in b and returns it together with the
+// number of bytes consumed. It returns 0 bytes when b holds an incomplete
+// UTF-8 character or escape sequence.
+func parseKey(b []byte) (rune, int) {
+	if len(b) == 0 {
+		return keyUnknown, 0
+	}
+
+	if b[0] != keyEscape {
+		if !utf8.FullRune(b) {
+			return keyUnknown, 0
+		}
+		r, n := utf8.DecodeRune(b)
+		return r, n
+	}
+
+	if len(b) < 2 {
+		return keyUnknown, 0
+	}
+
+	switch b[1] {
+	case '[', 'O':
+		return parseEscapeSequence(b)
+	case 'b':
+		return keyWordLeft, 2
+	case 'f':
+		return keyWordRight, 2
+	case keyBackspace, keyCtrlH:
+		return keyDeleteWordBack, 2
+	}
+
+	return keyUnknown, 2
+}
+
+// parseEscapeSequence decodes a CSI ("ESC [") or SS3 ("ESC O") sequence
+func parseEscapeSequence(b []byte) (rune, int) {
+	end := -1
+	for i := 2; i < len(b); i++ {
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		if len(b) > 16 {
+			// Not a sequence we understand; drop the escape byte
+			return keyUnknown, 1
+		}
+		return keyUnknown, 0
+	}
+
+	params := string(b[2:end])
+	modified := strings.HasSuffix(params, ";5") || strings.HasSuffix(params, ";3")
+
+	switch b[end] {
+	case 'A':
+		return keyUp, end + 1
+	case 'B':
+		return keyDown, end + 1
+	case 'C':
+		if modified {
+			return keyWordRight, end + 1
+		}
+		return keyRight, end + 1
+	case 'D':
+		if modified {
+			return keyWordLeft, end + 1
+		}
+		return keyLeft, end + 1
+	case 'H':
+		return keyHome, end + 1
+	case 'F':
+		return keyEnd, end + 1
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome, end + 1
+		case "4", "8":
+			return keyEnd, end + 1
+		case "3":
+			return keyDelete, end + 1
+		}
+	}
+
+	return keyUnknown, end + 1
+}
+
+// isPrintable reports whether key should be inserted into the line
+func isPrintable(key rune) bool {
+	if key >= keyUnknown && key <= keyDeleteWordBack {
+		return false
+	}
+	return key != utf8.RuneError && unicode.IsPrint(key)
+}
+
+// stringWidth returns the number of terminal columns s occupies, ignoring
+// ANSI escape sequences such as colours
+func stringWidth(s string) int {
+	width := 0
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case inEscape:
+			if r >= 0x40 && r <= 0x7e && r != '[' {
+				inEscape = false
+			}
+		case r == keyEscape:
+			inEscape = true
+		default:
+			width += runeWidth(r)
+		}
+	}
+	return width
+}
+
+// runesWidth returns the number of terminal columns runes occupy
+func runesWidth(runes []rune) int {
+	width := 0
+	for _, r := range runes {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// runeWidth approximates the number of terminal columns r occupies: zero for
+// control and combining characters, two for East Asian wide characters and
+// emoji, one otherwise
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20, r == 0x7f:
+		return 0
+	case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r),
+		r == 0x200b, r == 0x200d, r >= 0xfe00 && r <= 0xfe0f:
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
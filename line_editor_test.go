@@ -0,0 +1,224 @@
+package sshserver
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		input string
+		key   rune
+		n     int
+	}{
+		{"", keyUnknown, 0},
+		{"a", 'a', 1},
+		{"ab", 'a', 1},
+		{"\r", '\r', 1},
+		{"\x7f", keyBackspace, 1},
+		{"é", 'é', 2},
+		{"日本", '日', 3},
+		// Incomplete UTF-8 waits for more input
+		{"\xc3", keyUnknown, 0},
+		{"\xe6\x97", keyUnknown, 0},
+		{"\xf0\x9f\x98", keyUnknown, 0},
+		{"\xff", 0xfffd, 1},
+		// Alt combinations
+		{"\x1b", keyUnknown, 0},
+		{"\x1bb", keyWordLeft, 2},
+		{"\x1bf", keyWordRight, 2},
+		{"\x1b\x7f", keyDeleteWordBack, 2},
+		{"\x1b\x08", keyDeleteWordBack, 2},
+		{"\x1bx", keyUnknown, 2},
+		// CSI and SS3 sequences
+		{"\x1b[A", keyUp, 3},
+		{"\x1b[Bx", keyDown, 3},
+		{"\x1b[C", keyRight, 3},
+		{"\x1b[D", keyLeft, 3},
+		{"\x1bOA", keyUp, 3},
+		{"\x1bOD", keyLeft, 3},
+		{"\x1b[H", keyHome, 3},
+		{"\x1b[F", keyEnd, 3},
+		{"\x1bOH", keyHome, 3},
+		{"\x1bOF", keyEnd, 3},
+		{"\x1b[1~", keyHome, 4},
+		{"\x1b[7~", keyHome, 4},
+		{"\x1b[4~", keyEnd, 4},
+		{"\x1b[8~", keyEnd, 4},
+		{"\x1b[3~", keyDelete, 4},
+		{"\x1b[1;5C", keyWordRight, 6},
+		{"\x1b[1;5D", keyWordLeft, 6},
+		{"\x1b[1;3C", keyWordRight, 6},
+		{"\x1b[1;3D", keyWordLeft, 6},
+		{"\x1b[1;2C", keyRight, 6},
+		{"\x1b[5~", keyUnknown, 4},
+		{"\x1b[200~", keyUnknown, 6},
+		// Incomplete sequences wait for more input
+		{"\x1b[", keyUnknown, 0},
+		{"\x1bO", keyUnknown, 0},
+		{"\x1b[1;5", keyUnknown, 0},
+		{"\x1b[3", keyUnknown, 0},
+		// An overlong unterminated sequence drops only the escape
+		{"\x1b[" + strings.Repeat("1", 20), keyUnknown, 1},
+	}
+	for _, tt := range tests {
+		key, n := parseKey([]byte(tt.input))
+		if key != tt.key || n != tt.n {
+			t.Errorf("parseKey(%q) = %#x, %d, want %#x, %d", tt.input, key, n, tt.key, tt.n)
+		}
+	}
+}
+
+func TestLineEditorEditing(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"typing", "hello\r", "hello"},
+		{"lf", "hello\n", "hello"},
+		{"utf-8", "héllo 日本\r", "héllo 日本"},
+		{"backspace", "abc\x7f\r", "ab"},
+		{"ctrl-h", "abc\x08\r", "ab"},
+		{"backspace utf-8", "日本語\x7f\r", "日本"},
+		{"backspace at start", "ab\x01\x7f\r", "ab"},
+		{"insert", "helo\x1b[D\x1b[Dl\r", "hello"},
+		{"insert utf-8", "日本\x1b[D語\r", "日語本"},
+		{"ctrl-b ctrl-f", "ac\x02\x02\x06b\r", "abc"},
+		{"left at start", "\x1b[Da\r", "a"},
+		{"right at end", "a\x1b[Cb\r", "ab"},
+		{"ctrl-a", "world\x01hello \r", "hello world"},
+		{"ctrl-e", "bc\x01a\x05d\r", "abcd"},
+		{"home end", "bc\x1b[Ha\x1b[Fd\r", "abcd"},
+		{"home end tilde", "bc\x1b[1~a\x1b[4~d\r", "abcd"},
+		{"delete", "abc\x1b[D\x1b[3~\r", "ab"},
+		{"delete at end", "abc\x1b[3~\r", "abc"},
+		{"ctrl-d", "abc\x01\x04\r", "bc"},
+		{"alt-b", "one two three\x1bb\x1bbX\r", "one Xtwo three"},
+		{"alt-f", "one two three\x01\x1bfX\r", "oneX two three"},
+		{"ctrl-left", "one two\x1b[1;5DX\r", "one Xtwo"},
+		{"ctrl-right", "one two\x01\x1b[1;5C\x1b[1;5CX\r", "one twoX"},
+		{"unknown keys", "a\x00\x1b[5~\x1bxb\r", "ab"},
+		// Kill and yank
+		{"ctrl-k", "hello world\x01\x1bf\x0b\r", "hello"},
+		{"ctrl-u", "hello world\x1bb\x15\r", "world"},
+		{"ctrl-w", "one two three\x17\r", "one two "},
+		{"ctrl-w spaces", "one two  \x17\r", "one "},
+		{"alt-backspace", "one two\x1b\x7f\r", "one "},
+		{"yank", "hello world\x17\x01\x19 \r", "world hello "},
+		{"yank twice", "ab\x15\x19\x19\r", "abab"},
+		{"yank ctrl-k", "abcd\x02\x02\x0b\x01\x19\r", "cdab"},
+		{"yank replaces", "one two\x17\x17three\x19\r", "threeone "},
+		{"yank nothing", "ab\x19\r", "ab"},
+		{"empty kill keeps yank", "ab\x15\x0b\x19\r", "ab"},
+	}
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			// Reading a byte at a time splits every escape sequence and
+			// multi-byte character across reads
+			var in io.Reader = strings.NewReader(tt.input)
+			if oneByte {
+				in = iotest.OneByteReader(in)
+			}
+			e := newLineEditor(in, io.Discard)
+			line, err := e.readLine("> ")
+			if err != nil || line != tt.want {
+				t.Errorf("%s (one byte reads %v): got %q, %v, want %q", tt.name, oneByte, line, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestLineEditorLines(t *testing.T) {
+	e := newLineEditor(strings.NewReader("one\r\ntwo\n\rthree\r"), io.Discard)
+	for _, want := range []string{"one", "two", "", "three"} {
+		line, err := e.readLine("> ")
+		if err != nil || line != want {
+			t.Fatalf("got %q, %v, want %q", line, err, want)
+		}
+	}
+	if _, err := e.readLine("> "); err != io.EOF {
+		t.Errorf("got %v at the end of input, want io.EOF", err)
+	}
+}
+
+func TestLineEditorInterrupt(t *testing.T) {
+	var out bytes.Buffer
+	e := newLineEditor(strings.NewReader("abc\x03\x04"), &out)
+
+	if _, err := e.readLine("> "); err != errInterrupt {
+		t.Errorf("Ctrl-C: got %v, want errInterrupt", err)
+	}
+	if !strings.HasSuffix(out.String(), "^C\r\n") {
+		t.Errorf("Ctrl-C: got output %q", out.String())
+	}
+	if _, err := e.readLine("> "); err != io.EOF {
+		t.Errorf("Ctrl-D: got %v, want io.EOF", err)
+	}
+}
+
+func TestLineEditorWrap(t *testing.T) {
+	var out bytes.Buffer
+	e := newLineEditor(strings.NewReader("abcdefgh\x01\x05\r"), &out)
+	e.setWidth(10)
+
+	line, err := e.readLine("> ")
+	if err != nil || line != "abcdefgh" {
+		t.Fatalf("got %q, %v", line, err)
+	}
+
+	want := "> abcdefg" +
+		// Filling the row redraws the line and forces the wrap
+		"\r> abcdefgh\r\n\x1b[J\r" +
+		// Ctrl-A goes back up to the first row after the prompt
+		"\x1b[1A\r\x1b[2C" +
+		// Ctrl-E returns to the start of the second row
+		"\x1b[1B\r" +
+		// Enter moves to the end, where the cursor already is
+		"\r\r\n"
+	if out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+}
+
+func TestLineEditorWrapRedraw(t *testing.T) {
+	var out bytes.Buffer
+	e := newLineEditor(strings.NewReader("abcdefghijkl\x01\x0b\r"), &out)
+	e.setWidth(10)
+
+	if _, err := e.readLine("> "); err != nil {
+		t.Fatal(err)
+	}
+	// Ctrl-K on the wrapped line redraws from the prompt, clears the rows
+	// below and leaves the cursor after the prompt
+	want := "\x1b[1A\r\x1b[2C" + "\r> \x1b[J\r\x1b[2C" + "\r\x1b[2C\r\n"
+	if !strings.HasSuffix(out.String(), want) {
+		t.Errorf("got output %q, want suffix %q", out.String(), want)
+	}
+}
+
+func TestLineEditorPosition(t *testing.T) {
+	e := newLineEditor(nil, io.Discard)
+	e.setWidth(10)
+	e.prompt = "\x1b[32m>\x1b[0m "
+	e.line = []rune("日本語ab")
+
+	tests := []struct {
+		i        int
+		col, row int
+	}{
+		{0, 2, 0},
+		{1, 4, 0},
+		{3, 8, 0},
+		{4, 9, 0},
+		{5, 0, 1},
+	}
+	for _, tt := range tests {
+		if col, row := e.position(tt.i); col != tt.col || row != tt.row {
+			t.Errorf("position(%d) = %d, %d, want %d, %d", tt.i, col, row, tt.col, tt.row)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package sshserver
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordAuthenticator checks the passwords of clients using password
+// authentication
+type PasswordAuthenticator interface {
+	// AuthenticatePassword returns nil if password is correct for user
+	AuthenticatePassword(user string, password []byte) error
+}
+
+// HtpasswdAuthenticator checks passwords against an htpasswd-style file with
+// one "user:hash" entry per line. Hashes may be bcrypt ("$2y$...", as written
+// by "htpasswd -B") or argon2 in PHC format
+// ("$argon2id$v=19$m=65536,t=3,p=4$salt$key"). Blank lines and lines starting
+// with '#' are ignored. The file is read on every attempt, so changes take
+// effect immediately.
+type HtpasswdAuthenticator struct {
+	path string
+}
+
+// NewHtpasswdAuthenticator creates a PasswordAuthenticator for the htpasswd
+// file at path
+func NewHtpasswdAuthenticator(path string) *HtpasswdAuthenticator {
+	return &HtpasswdAuthenticator{path: path}
+}
+
+// dummyHash is compared against when the user doesn't exist, so unknown users
+// take as long to reject as wrong passwords
+var dummyHash = []byte("$2a$10$Hg1JfKwUSKkDLYO3Fk5Pdu9PG6JvEVtXympyRV3ng23Q1LS/KTMDi")
+
+// AuthenticatePassword implements PasswordAuthenticator
+func (h *HtpasswdAuthenticator) AuthenticatePassword(user string, password []byte) error {
+	hash, err := h.lookup(user)
+	if err != nil {
+		return err
+	}
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, password)
+		return fmt.Errorf("unknown user %q", user)
+	}
+
+	ok, err := verifyPasswordHash(hash, password)
+	if err != nil {
+		return fmt.Errorf("invalid password hash for %q: %v", user, err)
+	}
+	if !ok {
+		return fmt.Errorf("wrong password for %q", user)
+	}
+	return nil
+}
+
+// lookup returns the hash stored for user, or "" if there is none
+func (h *HtpasswdAuthenticator) lookup(user string) (string, error) {
+	file, err := os.Open(h.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open password file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, hash, ok := strings.Cut(line, ":")
+		if ok && name == user {
+			return hash, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read password file: %v", err)
+	}
+
+	return "", nil
+}
+
+// HashPassword returns a bcrypt hash of password suitable for an htpasswd
+// file
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// verifyPasswordHash reports whether password matches a bcrypt or argon2 hash
+func verifyPasswordHash(hash string, password []byte) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), password)
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(hash, "$argon2id$"), strings.HasPrefix(hash, "$argon2i$"):
+		return verifyArgon2(hash, password)
+	default:
+		return false, fmt.Errorf("unsupported hash format")
+	}
+}
+
+// verifyArgon2 checks password against an argon2 hash in PHC string format
+func verifyArgon2(hash string, password []byte) (bool, error) {
+	// "", variant, "v=19", "m=65536,t=3,p=4", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("malformed argon2 hash")
+	}
+
+	if parts[2] != "v=19" {
+		return false, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var memory, iterations, threads uint64
+	for _, param := range strings.Split(parts[3], ",") {
+		key, value, _ := strings.Cut(param, "=")
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid argon2 parameter %q", param)
+		}
+		switch key {
+		case "m":
+			memory = n
+		case "t":
+			iterations = n
+		case "p":
+			threads = n
+		}
+	}
+	if memory == 0 || iterations == 0 || threads == 0 || threads > 255 {
+		return false, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2 salt: %v", err)
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false, fmt.Errorf("invalid argon2 key")
+	}
+
+	var got []byte
+	if parts[1] == "argon2id" {
+		got = argon2.IDKey(password, salt, uint32(iterations), uint32(memory), uint8(threads), uint32(len(want)))
+	} else {
+		got = argon2.Key(password, salt, uint32(iterations), uint32(memory), uint8(threads), uint32(len(want)))
+	}
+
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
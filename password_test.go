@@ -0,0 +1,160 @@
+package sshserver
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestArgon2 returns a cheap argon2id PHC hash of password
+func newTestArgon2(password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	return "$argon2id$v=19$m=64,t=1,p=1$" + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(key)
+}
+
+// newTestHtpasswd writes lines to a password file and returns its
+// authenticator
+func newTestHtpasswd(t *testing.T, lines ...string) *HtpasswdAuthenticator {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return NewHtpasswdAuthenticator(path)
+}
+
+func TestHtpasswdAuthenticator(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHash, err := bcrypt.GenerateFromPassword([]byte("other"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := newTestHtpasswd(t,
+		"# operators",
+		"",
+		"alice:"+string(bcryptHash),
+		"   ",
+		"bob:"+newTestArgon2("hunter2"),
+		"  # carol:"+string(bcryptHash),
+		// The first entry for a user wins
+		"alice:"+string(otherHash),
+		"dave:plaintext",
+	)
+
+	tests := []struct {
+		user, password string
+		ok             bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"alice", "other", false},
+		{"bob", "hunter2", true},
+		{"bob", "hunter3", false},
+		{"carol", "secret", false},
+		{"# carol", "secret", false},
+		{"dave", "plaintext", false},
+		{"mallory", "secret", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		err := auth.AuthenticatePassword(tt.user, []byte(tt.password))
+		if (err == nil) != tt.ok {
+			t.Errorf("%q/%q: got %v, want ok=%v", tt.user, tt.password, err, tt.ok)
+		}
+	}
+}
+
+func TestHtpasswdMissingFile(t *testing.T) {
+	auth := NewHtpasswdAuthenticator(filepath.Join(t.TempDir(), "missing"))
+	if err := auth.AuthenticatePassword("alice", []byte("secret")); err == nil {
+		t.Error("authenticated without a password file")
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	// Unknown users are only as slow to reject as known ones if the dummy
+	// hash is a real bcrypt hash at the cost HashPassword uses
+	cost, err := bcrypt.Cost(dummyHash)
+	if err != nil {
+		t.Fatalf("dummy hash is not a bcrypt hash: %v", err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost is %d, want %d", cost, bcrypt.DefaultCost)
+	}
+	if err := bcrypt.CompareHashAndPassword(dummyHash, []byte("secret")); err != bcrypt.ErrMismatchedHashAndPassword {
+		t.Errorf("comparing against the dummy hash: got %v, want a mismatch", err)
+	}
+}
+
+func TestVerifyArgon2(t *testing.T) {
+	hash := newTestArgon2("secret")
+	parts := strings.Split(hash, "$")
+	salt, key := parts[4], parts[5]
+
+	if ok, err := verifyArgon2(hash, []byte("secret")); !ok || err != nil {
+		t.Errorf("correct password: got %v, %v", ok, err)
+	}
+	if ok, err := verifyArgon2(hash, []byte("wrong")); ok || err != nil {
+		t.Errorf("wrong password: got %v, %v", ok, err)
+	}
+
+	// argon2i hashes are checked with the argon2i function
+	argon2i := "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" +
+		base64.RawStdEncoding.EncodeToString(argon2.Key([]byte("secret"), []byte("0123456789abcdef"), 1, 64, 1, 32))
+	if ok, err := verifyArgon2(argon2i, []byte("secret")); !ok || err != nil {
+		t.Errorf("argon2i: got %v, %v", ok, err)
+	}
+
+	malformed := []string{
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt,
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$extra",
+		"$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key,
+		"$argon2id$v=19$m=x,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=-64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$not*base64$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$not*base64",
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$",
+	}
+	for _, hash := range malformed {
+		if ok, err := verifyArgon2(hash, []byte("secret")); ok || err == nil {
+			t.Errorf("%q: got %v, %v, want an error", hash, ok, err)
+		}
+	}
+}
+
+func TestVerifyPasswordHash(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// htpasswd -B writes $2y$, which is the same algorithm as $2a$
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		h := prefix + strings.TrimPrefix(string(hash), "$2a$")
+		if ok, err := verifyPasswordHash(h, []byte("secret")); !ok || err != nil {
+			t.Errorf("%s: got %v, %v", prefix, ok, err)
+		}
+		if ok, err := verifyPasswordHash(h, []byte("wrong")); ok || err != nil {
+			t.Errorf("%s wrong password: got %v, %v", prefix, ok, err)
+		}
+	}
+
+	for _, h := range []string{"secret", "$1$salt$hash", "{SHA}abc", "$2y$10$short"} {
+		if ok, err := verifyPasswordHash(h, []byte("secret")); ok || err == nil {
+			t.Errorf("%q: got %v, %v, want an error", h, ok, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package sshserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Extensions granting features to restricted credentials, as in OpenSSH
+// certificates
+const (
+	permitPty             = "permit-pty"
+	permitPortForwarding  = "permit-port-forwarding"
+	permitAgentForwarding = "permit-agent-forwarding"
+)
+
+// restrictExtension marks permissions that grant only the features listed in
+// their "permit-*" extensions. Certificates are always restricted this way.
+const restrictExtension = "restrict"
+
+// Critical options enforced by the server
+const (
+	forceCommandOption  = "force-command"
+	sourceAddressOption = "source-address"
+)
+
+// permitted reports whether the extensions recorded during authentication
+// grant feature. Unrestricted credentials are granted everything.
+func permitted(extensions map[string]string, feature string) bool {
+	if _, restricted := extensions[restrictExtension]; !restricted {
+		return true
+	}
+	_, ok := extensions[feature]
+	return ok
+}
+
+// connPermitted is permitted for the permissions of an established connection
+func connPermitted(conn *ssh.ServerConn, feature string) bool {
+	if conn.Permissions == nil {
+		return true
+	}
+	return permitted(conn.Permissions.Extensions, feature)
+}
+
+// checkSourceAddress returns an error unless addr is in the comma-separated
+// list of addresses and CIDR ranges
+func checkSourceAddress(addr net.Addr, list string) error {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("cannot check source address of %s", addr)
+	}
+
+	for _, entry := range strings.Split(list, ",") {
+		if strings.Contains(entry, "/") {
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return fmt.Errorf("invalid source address %q: %v", entry, err)
+			}
+			if ipNet.Contains(tcpAddr.IP) {
+				return nil
+			}
+			continue
+		}
+
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return fmt.Errorf("invalid source address %q", entry)
+		}
+		if ip.Equal(tcpAddr.IP) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("source address %s is not allowed", tcpAddr.IP)
+}
@@ -0,0 +1,110 @@
+package sshserver
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ttyOpEnd terminates the encoded terminal modes of a pty-req (RFC 4254 8)
+const ttyOpEnd = 0
+
+// Window describes the size of the client's terminal
+type Window struct {
+	// Width is the terminal width in characters
+	Width uint32
+
+	// Height is the terminal height in rows
+	Height uint32
+
+	// PixelWidth is the terminal width in pixels, or zero if unknown
+	PixelWidth uint32
+
+	// PixelHeight is the terminal height in pixels, or zero if unknown
+	PixelHeight uint32
+}
+
+// PtyInfo describes the pseudo-terminal requested by the client
+type PtyInfo struct {
+	// Term is the value of the client's TERM variable (e.g. "xterm-256color")
+	Term string
+
+	// Window is the current terminal size, updated on window-change requests
+	Window
+
+	// Modes holds the terminal modes sent by the client (e.g. ssh.ECHO)
+	Modes ssh.TerminalModes
+}
+
+// parsePtyRequest decodes the payload of a "pty-req" channel request
+func parsePtyRequest(payload []byte) (PtyInfo, error) {
+	var req struct {
+		Term        string
+		Columns     uint32
+		Rows        uint32
+		PixelWidth  uint32
+		PixelHeight uint32
+		Modelist    string
+	}
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return PtyInfo{}, fmt.Errorf("invalid pty-req payload: %v", err)
+	}
+
+	modes, err := parseTerminalModes([]byte(req.Modelist))
+	if err != nil {
+		return PtyInfo{}, err
+	}
+
+	return PtyInfo{
+		Term: req.Term,
+		Window: Window{
+			Width:       req.Columns,
+			Height:      req.Rows,
+			PixelWidth:  req.PixelWidth,
+			PixelHeight: req.PixelHeight,
+		},
+		Modes: modes,
+	}, nil
+}
+
+// parseWindowChange decodes the payload of a "window-change" channel request
+func parseWindowChange(payload []byte) (Window, error) {
+	var req struct {
+		Columns     uint32
+		Rows        uint32
+		PixelWidth  uint32
+		PixelHeight uint32
+	}
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return Window{}, fmt.Errorf("invalid window-change payload: %v", err)
+	}
+
+	return Window{
+		Width:       req.Columns,
+		Height:      req.Rows,
+		PixelWidth:  req.PixelWidth,
+		PixelHeight: req.PixelHeight,
+	}, nil
+}
+
+// parseTerminalModes decodes the opcode/argument pairs of an encoded
+// terminal mode list
+func parseTerminalModes(b []byte) (ssh.TerminalModes, error) {
+	modes := make(ssh.TerminalModes)
+
+	for len(b) > 0 {
+		opcode := b[0]
+		if opcode == ttyOpEnd || opcode >= 160 {
+			// Opcodes 160 and above have undefined arguments; stop parsing
+			break
+		}
+		if len(b) < 5 {
+			return nil, fmt.Errorf("truncated terminal mode %d", opcode)
+		}
+		modes[opcode] = binary.BigEndian.Uint32(b[1:5])
+		b = b[5:]
+	}
+
+	return modes, nil
+}
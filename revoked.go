@@ -0,0 +1,311 @@
+package sshserver
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// krlMagic starts OpenSSH key revocation lists, as written by "ssh-keygen -k"
+const krlMagic = "SSHKRL\n\x00"
+
+// KRL section types, see PROTOCOL.krl in the OpenSSH sources
+const (
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+	krlSectionExtension         = 255
+
+	krlCertSerialList   = 0x20
+	krlCertSerialRange  = 0x21
+	krlCertSerialBitmap = 0x22
+	krlCertKeyID        = 0x23
+	krlCertExtension    = 0x39
+)
+
+// revokedKeys is a parsed Config.RevokedKeysFile
+type revokedKeys struct {
+	keys   map[string]bool
+	sha1   map[string]bool
+	sha256 map[string]bool
+	certs  []revokedCerts
+}
+
+// revokedCerts lists the certificates revoked for one CA, or for any CA when
+// ca is nil
+type revokedCerts struct {
+	ca      []byte
+	serials map[uint64]bool
+	ranges  [][2]uint64
+	bitmaps []serialBitmap
+	keyIDs  map[string]bool
+}
+
+// serialBitmap revokes serial offset+i for every bit i set in bits
+type serialBitmap struct {
+	offset uint64
+	bits   *big.Int
+}
+
+// loadRevokedKeys reads a revocation list, either an OpenSSH KRL or a text
+// file with one public key or SHA256 fingerprint per line
+func loadRevokedKeys(path string) (*revokedKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revoked keys file: %v", err)
+	}
+
+	r := &revokedKeys{
+		keys:   make(map[string]bool),
+		sha1:   make(map[string]bool),
+		sha256: make(map[string]bool),
+	}
+
+	if bytes.HasPrefix(data, []byte(krlMagic)) {
+		if err := r.parseKRL(data[len(krlMagic):]); err != nil {
+			return nil, fmt.Errorf("invalid key revocation list: %v", err)
+		}
+		return r, nil
+	}
+
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if fp, ok := strings.CutPrefix(line, "SHA256:"); ok {
+			sum, err := base64.RawStdEncoding.DecodeString(fp)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fingerprint on line %d of revoked keys file", n+1)
+			}
+			r.sha256[string(sum)] = true
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key on line %d of revoked keys file: %v", n+1, err)
+		}
+		r.keys[string(key.Marshal())] = true
+	}
+
+	return r, nil
+}
+
+// keyRevoked reports whether key is revoked
+func (r *revokedKeys) keyRevoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	sum1 := sha1.Sum(blob)
+	sum256 := sha256.Sum256(blob)
+	return r.keys[string(blob)] || r.sha1[string(sum1[:])] || r.sha256[string(sum256[:])]
+}
+
+// certRevoked reports whether cert, its key or the CA that signed it is
+// revoked. It has the signature of ssh.CertChecker.IsRevoked.
+func (r *revokedKeys) certRevoked(cert *ssh.Certificate) bool {
+	if r.keyRevoked(cert) || r.keyRevoked(cert.Key) || r.keyRevoked(cert.SignatureKey) {
+		return true
+	}
+
+	ca := cert.SignatureKey.Marshal()
+	for _, rc := range r.certs {
+		if rc.ca != nil && !bytes.Equal(rc.ca, ca) {
+			continue
+		}
+		if rc.keyIDs[cert.KeyId] || rc.serials[cert.Serial] {
+			return true
+		}
+		for _, rng := range rc.ranges {
+			if cert.Serial >= rng[0] && cert.Serial <= rng[1] {
+				return true
+			}
+		}
+		for _, bm := range rc.bitmaps {
+			if cert.Serial >= bm.offset && cert.Serial-bm.offset < uint64(bm.bits.BitLen()) &&
+				bm.bits.Bit(int(cert.Serial-bm.offset)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseKRL parses the body of a binary KRL following the magic
+func (r *revokedKeys) parseKRL(data []byte) error {
+	in := &krlReader{data: data}
+	if version := in.uint32(); in.err == nil && version != 1 {
+		return fmt.Errorf("unsupported format version %d", version)
+	}
+	in.uint64() // krl_version
+	in.uint64() // generated_date
+	in.uint64() // flags
+	in.string() // reserved
+	in.string() // comment
+
+	for in.err == nil && len(in.data) > 0 {
+		sectionType := in.byte()
+		section := &krlReader{data: in.string()}
+		if in.err != nil {
+			break
+		}
+
+		switch sectionType {
+		case krlSectionCertificates:
+			if err := r.parseKRLCertificates(section); err != nil {
+				return err
+			}
+		case krlSectionExplicitKey:
+			for section.err == nil && len(section.data) > 0 {
+				key, err := ssh.ParsePublicKey(section.string())
+				if err != nil {
+					return fmt.Errorf("invalid revoked key: %v", err)
+				}
+				r.keys[string(key.Marshal())] = true
+			}
+		case krlSectionFingerprintSHA1, krlSectionFingerprintSHA256:
+			hashes := r.sha1
+			if sectionType == krlSectionFingerprintSHA256 {
+				hashes = r.sha256
+			}
+			for section.err == nil && len(section.data) > 0 {
+				hashes[string(section.string())] = true
+			}
+		case krlSectionSignature:
+			// Signatures come last and are not verified
+			return nil
+		case krlSectionExtension:
+			if err := section.extension(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown section type %d", sectionType)
+		}
+
+		if section.err != nil {
+			return section.err
+		}
+	}
+
+	return in.err
+}
+
+// parseKRLCertificates parses a certificates section
+func (r *revokedKeys) parseKRLCertificates(in *krlReader) error {
+	rc := revokedCerts{
+		serials: make(map[uint64]bool),
+		keyIDs:  make(map[string]bool),
+	}
+
+	if ca := in.string(); len(ca) > 0 {
+		key, err := ssh.ParsePublicKey(ca)
+		if err != nil {
+			return fmt.Errorf("invalid CA key: %v", err)
+		}
+		rc.ca = key.Marshal()
+	}
+	in.string() // reserved
+
+	for in.err == nil && len(in.data) > 0 {
+		subType := in.byte()
+		sub := &krlReader{data: in.string()}
+		if in.err != nil {
+			break
+		}
+
+		switch subType {
+		case krlCertSerialList:
+			for sub.err == nil && len(sub.data) > 0 {
+				rc.serials[sub.uint64()] = true
+			}
+		case krlCertSerialRange:
+			rc.ranges = append(rc.ranges, [2]uint64{sub.uint64(), sub.uint64()})
+		case krlCertSerialBitmap:
+			offset := sub.uint64()
+			rc.bitmaps = append(rc.bitmaps, serialBitmap{offset: offset, bits: new(big.Int).SetBytes(sub.string())})
+		case krlCertKeyID:
+			for sub.err == nil && len(sub.data) > 0 {
+				rc.keyIDs[string(sub.string())] = true
+			}
+		case krlCertExtension:
+			if err := sub.extension(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown certificate section type %#x", subType)
+		}
+
+		if sub.err != nil {
+			return sub.err
+		}
+	}
+
+	r.certs = append(r.certs, rc)
+	return in.err
+}
+
+// krlReader decodes the SSH wire encoding used by KRLs. The first error is
+// kept and later reads return zero values.
+type krlReader struct {
+	data []byte
+	err  error
+}
+
+func (in *krlReader) next(n int) []byte {
+	if in.err != nil {
+		return nil
+	}
+	if len(in.data) < n {
+		in.err = fmt.Errorf("truncated data")
+		return nil
+	}
+	b := in.data[:n]
+	in.data = in.data[n:]
+	return b
+}
+
+func (in *krlReader) byte() byte {
+	if b := in.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (in *krlReader) uint32() uint32 {
+	if b := in.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (in *krlReader) uint64() uint64 {
+	if b := in.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (in *krlReader) string() []byte {
+	return in.next(int(in.uint32()))
+}
+
+// extension skips an extension section, failing if it is marked critical
+func (in *krlReader) extension() error {
+	name := in.string()
+	critical := in.byte() != 0
+	in.string() // data
+	if in.err == nil && critical {
+		return fmt.Errorf("unsupported critical extension %q", name)
+	}
+	return in.err
+}
@@ -0,0 +1,215 @@
+package sshserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// krlWriter builds KRLs in the SSH wire encoding
+type krlWriter struct {
+	b []byte
+}
+
+func (w *krlWriter) byte(v byte) *krlWriter {
+	w.b = append(w.b, v)
+	return w
+}
+
+func (w *krlWriter) uint32(v uint32) *krlWriter {
+	w.b = binary.BigEndian.AppendUint32(w.b, v)
+	return w
+}
+
+func (w *krlWriter) uint64(v uint64) *krlWriter {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+	return w
+}
+
+func (w *krlWriter) string(s []byte) *krlWriter {
+	w.uint32(uint32(len(s)))
+	w.b = append(w.b, s...)
+	return w
+}
+
+// section appends a section of type typ holding body
+func (w *krlWriter) section(typ byte, body *krlWriter) *krlWriter {
+	return w.byte(typ).string(body.b)
+}
+
+func newKRL() *krlWriter {
+	w := &krlWriter{b: []byte(krlMagic)}
+	return w.uint32(1).uint64(1).uint64(0).uint64(0).string(nil).string([]byte("test"))
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newTestCert(t *testing.T, ca ssh.Signer, serial uint64, keyID string) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             newTestPublicKey(t),
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeRevokedKeys(t *testing.T, data []byte) *revokedKeys {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "revoked")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := loadRevokedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRevokedKeysText(t *testing.T) {
+	byKey, byFingerprint, other := newTestPublicKey(t), newTestPublicKey(t), newTestPublicKey(t)
+	data := "# revoked\n" +
+		string(ssh.MarshalAuthorizedKey(byKey)) +
+		"\n  " + ssh.FingerprintSHA256(byFingerprint) + "\n"
+	r := writeRevokedKeys(t, []byte(data))
+
+	if !r.keyRevoked(byKey) || !r.keyRevoked(byFingerprint) {
+		t.Error("listed key not revoked")
+	}
+	if r.keyRevoked(other) {
+		t.Error("unlisted key revoked")
+	}
+}
+
+func TestRevokedKeysKRL(t *testing.T) {
+	explicit, bySHA1, bySHA256, other := newTestPublicKey(t), newTestPublicKey(t), newTestPublicKey(t), newTestPublicKey(t)
+	ca, otherCA := newTestSigner(t), newTestSigner(t)
+
+	sum1 := sha1.Sum(bySHA1.Marshal())
+	sum256 := sha256.Sum256(bySHA256.Marshal())
+	krl := newKRL().
+		section(krlSectionExplicitKey, new(krlWriter).string(explicit.Marshal())).
+		section(krlSectionFingerprintSHA1, new(krlWriter).string(sum1[:])).
+		section(krlSectionFingerprintSHA256, new(krlWriter).string(sum256[:])).
+		section(krlSectionExtension, new(krlWriter).string([]byte("ignored@example.com")).byte(0).string(nil)).
+		section(krlSectionCertificates, new(krlWriter).
+			string(ca.PublicKey().Marshal()).
+			string(nil).
+			section(krlCertSerialList, new(krlWriter).uint64(5).uint64(9)).
+			section(krlCertSerialRange, new(krlWriter).uint64(100).uint64(199)).
+			// Bits 0, 2 and 9 of offset 1000 revoke 1000, 1002 and 1009
+			section(krlCertSerialBitmap, new(krlWriter).uint64(1000).string([]byte{0x02, 0x05})).
+			section(krlCertKeyID, new(krlWriter).string([]byte("stolen laptop")))).
+		section(krlSectionCertificates, new(krlWriter).
+			string(nil).
+			string(nil).
+			section(krlCertKeyID, new(krlWriter).string([]byte("any ca")))).
+		section(krlSectionSignature, new(krlWriter).string([]byte("unverified")))
+
+	r := writeRevokedKeys(t, krl.b)
+
+	for name, key := range map[string]ssh.PublicKey{"explicit": explicit, "sha1": bySHA1, "sha256": bySHA256} {
+		if !r.keyRevoked(key) {
+			t.Errorf("%s key not revoked", name)
+		}
+	}
+	if r.keyRevoked(other) {
+		t.Error("unlisted key revoked")
+	}
+
+	tests := []struct {
+		ca     ssh.Signer
+		serial uint64
+		keyID  string
+		want   bool
+	}{
+		{ca, 5, "", true},
+		{ca, 6, "", false},
+		{ca, 100, "", true},
+		{ca, 199, "", true},
+		{ca, 200, "", false},
+		{ca, 1000, "", true},
+		{ca, 1001, "", false},
+		{ca, 1002, "", true},
+		{ca, 1009, "", true},
+		{ca, 1010, "", false},
+		{ca, 999, "", false},
+		{ca, 7, "stolen laptop", true},
+		// Serials and key IDs only apply to the CA they are listed under
+		{otherCA, 5, "stolen laptop", false},
+		{otherCA, 7, "any ca", true},
+	}
+	for _, tt := range tests {
+		cert := newTestCert(t, tt.ca, tt.serial, tt.keyID)
+		if got := r.certRevoked(cert); got != tt.want {
+			t.Errorf("serial %d key ID %q: revoked = %v, want %v", tt.serial, tt.keyID, got, tt.want)
+		}
+	}
+
+	// A certificate whose key is revoked is revoked too
+	cert := newTestCert(t, otherCA, 1, "")
+	cert.Key = explicit
+	if err := cert.SignCert(rand.Reader, otherCA); err != nil {
+		t.Fatal(err)
+	}
+	if !r.certRevoked(cert) {
+		t.Error("certificate for a revoked key accepted")
+	}
+}
+
+func TestRevokedKeysInvalid(t *testing.T) {
+	key := newTestPublicKey(t)
+	tests := map[string][]byte{
+		"bad key line":         []byte("ssh-ed25519 AAAAnotbase64\n"),
+		"bad fingerprint":      []byte("SHA256:!!!\n"),
+		"version":              append([]byte(krlMagic), 0, 0, 0, 2),
+		"truncated header":     newKRL().b[:20],
+		"truncated section":    newKRL().byte(krlSectionExplicitKey).uint32(100).b,
+		"unknown section":      newKRL().section(42, new(krlWriter)).b,
+		"critical extension":   newKRL().section(krlSectionExtension, new(krlWriter).string([]byte("x@example.com")).byte(1).string(nil)).b,
+		"bad explicit key":     newKRL().section(krlSectionExplicitKey, new(krlWriter).string([]byte("junk"))).b,
+		"bad CA key":           newKRL().section(krlSectionCertificates, new(krlWriter).string([]byte("junk")).string(nil)).b,
+		"unknown cert section": newKRL().section(krlSectionCertificates, new(krlWriter).string(nil).string(nil).section(0x30, new(krlWriter))).b,
+		"short serial range":   newKRL().section(krlSectionCertificates, new(krlWriter).string(nil).string(nil).section(krlCertSerialRange, new(krlWriter).uint64(1))).b,
+		"truncated key list":   newKRL().section(krlSectionExplicitKey, new(krlWriter).string(key.Marshal()).uint32(9)).b,
+	}
+	for name, data := range tests {
+		path := filepath.Join(t.TempDir(), "revoked")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadRevokedKeys(path); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+
+	if _, err := loadRevokedKeys(filepath.Join(t.TempDir(), "missing")); err == nil || !strings.Contains(err.Error(), "revoked keys file") {
+		t.Errorf("missing file: got %v", err)
+	}
+}
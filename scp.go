@@ -0,0 +1,651 @@
+package sshserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// scpCommand is a parsed "scp -t" (upload) or "scp -f" (download) command
+type scpCommand struct {
+	sink      bool
+	recursive bool
+	preserve  bool
+	targetDir bool
+	paths     []string
+}
+
+// parseSCPCommand parses the command line an scp client runs on the server.
+// It fails for anything that isn't an scp protocol invocation.
+func parseSCPCommand(command string) (*scpCommand, error) {
+	args, err := splitCommandLine(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || args[0] != "scp" {
+		return nil, fmt.Errorf("not an scp command")
+	}
+
+	cmd := &scpCommand{}
+	var source bool
+	args = args[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 't':
+				cmd.sink = true
+			case 'f':
+				source = true
+			case 'r':
+				cmd.recursive = true
+			case 'p':
+				cmd.preserve = true
+			case 'd':
+				cmd.targetDir = true
+			case 'v', 'q':
+			default:
+				return nil, fmt.Errorf("unsupported scp option -%c", flag)
+			}
+		}
+	}
+
+	switch {
+	case cmd.sink == source:
+		return nil, fmt.Errorf("scp requires exactly one of -t and -f")
+	case len(args) == 0:
+		return nil, fmt.Errorf("scp requires a path")
+	case cmd.sink && len(args) != 1:
+		return nil, fmt.Errorf("scp -t requires a single target")
+	}
+
+	cmd.paths = args
+	return cmd, nil
+}
+
+// handleSCP serves an scp upload or download and closes the channel
+func (s *Server) handleSCP(channel ssh.Channel, sess *Session, cmd *scpCommand) {
+	defer channel.Close()
+
+	cfg := s.config.SCP
+	fsys, err := openFileSystem(sess, cfg.Root, cfg.PerUserRoot, cfg.ReadOnly, cfg.FileSystem)
+	if err != nil {
+		s.logger.Printf("Failed to open scp filesystem for user %s: %v", sess.User, err)
+		fmt.Fprintf(channel, "\x02scp: %v\n", err)
+		sendExitStatus(channel, 1)
+		return
+	}
+	if c, ok := fsys.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	t := &scpTransfer{
+		r:       bufio.NewReader(channel),
+		w:       channel,
+		fsys:    fsys,
+		cmd:     cmd,
+		maxSize: cfg.MaxFileSize,
+		logf: func(format string, args ...interface{}) {
+			s.logger.Printf("scp (user %s): %s", sess.User, fmt.Sprintf(format, args...))
+		},
+	}
+
+	if cmd.sink {
+		s.logger.Printf("Starting scp upload to %s for user %s", cmd.paths[0], sess.User)
+		err = t.sink(cmd.paths[0])
+	} else {
+		s.logger.Printf("Starting scp download of %s for user %s", strings.Join(cmd.paths, " "), sess.User)
+		err = t.source(cmd.paths)
+	}
+	if err != nil {
+		t.logf("%v", err)
+		t.errs++
+	}
+
+	if t.errs > 0 {
+		sendExitStatus(channel, 1)
+		return
+	}
+	sendExitStatus(channel, 0)
+}
+
+// scpTransfer runs one side of the scp protocol. Every protocol line is
+// answered with a zero byte on success, 1 followed by a message for an error
+// that only affects the current file, or 2 and a message for a fatal error.
+type scpTransfer struct {
+	r       *bufio.Reader
+	w       io.Writer
+	fsys    FileSystem
+	cmd     *scpCommand
+	maxSize int64
+	errs    int
+	logf    func(format string, args ...interface{})
+}
+
+// scpTimes holds the times sent in a "T" line
+type scpTimes struct {
+	mtime time.Time
+	atime time.Time
+}
+
+// scpDir is a directory being received
+type scpDir struct {
+	name  string
+	times *scpTimes
+}
+
+func (t *scpTransfer) ack() error {
+	_, err := t.w.Write([]byte{0})
+	return err
+}
+
+// warn reports an error affecting a single file; the transfer continues
+func (t *scpTransfer) warn(err error) error {
+	t.errs++
+	t.logf("%v", err)
+	_, werr := fmt.Fprintf(t.w, "\x01scp: %v\n", scpErrorText(err))
+	return werr
+}
+
+// readAck reads the peer's response to the last line sent
+func (t *scpTransfer) readAck() error {
+	b, err := t.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+
+	msg, err := t.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	msg = strings.TrimSuffix(msg, "\n")
+	if b == 1 {
+		return &scpWarning{msg}
+	}
+	return errors.New(msg)
+}
+
+// scpWarning is a non-fatal error reported by the client
+type scpWarning struct {
+	msg string
+}
+
+func (w *scpWarning) Error() string {
+	return w.msg
+}
+
+// sink receives files into target
+func (t *scpTransfer) sink(target string) error {
+	target = fsName(target)
+
+	targetIsDir := false
+	if info, err := t.fsys.Stat(target); err == nil && info.IsDir() {
+		targetIsDir = true
+	}
+	if t.cmd.targetDir && !targetIsDir {
+		fmt.Fprintf(t.w, "\x02scp: %s: Not a directory\n", target)
+		return fmt.Errorf("%s: not a directory", target)
+	}
+
+	if err := t.ack(); err != nil {
+		return err
+	}
+
+	// dirs holds the directories entered with "D" lines; names received at
+	// the top level go into target, or become target if it isn't a directory
+	var dirs []scpDir
+	var times *scpTimes
+	for {
+		line, err := t.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			if len(dirs) > 0 {
+				return fmt.Errorf("connection closed inside a directory")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fmt.Errorf("empty protocol line")
+		}
+
+		switch line[0] {
+		case 'T':
+			if times, err = parseSCPTimes(line[1:]); err != nil {
+				fmt.Fprintf(t.w, "\x02scp: %v\n", err)
+				return err
+			}
+			if err := t.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'C', 'D':
+			mode, size, name, err := parseSCPHeader(line[1:])
+			if err != nil {
+				fmt.Fprintf(t.w, "\x02scp: %v\n", err)
+				return err
+			}
+
+			dest := target
+			if len(dirs) > 0 {
+				dest = path.Join(dirs[len(dirs)-1].name, name)
+			} else if targetIsDir {
+				dest = path.Join(target, name)
+			}
+
+			if line[0] == 'C' {
+				err = t.receiveFile(dest, mode, size, times)
+			} else if err = t.enterDir(dest, mode); err == nil {
+				dirs = append(dirs, scpDir{name: dest, times: times})
+			}
+			times = nil
+			if err != nil {
+				var fatal *scpFatalError
+				if errors.As(err, &fatal) {
+					return err
+				}
+				if err := t.warn(err); err != nil {
+					return err
+				}
+				continue
+			}
+		case 'E':
+			if len(dirs) == 0 {
+				fmt.Fprintf(t.w, "\x02scp: unexpected end of directory\n")
+				return fmt.Errorf("unexpected end of directory")
+			}
+			// Directory times are set once its contents are written
+			dir := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			if dir.times != nil && t.cmd.preserve {
+				if err := t.fsys.Chtimes(dir.name, dir.times.atime, dir.times.mtime); err != nil {
+					t.errs++
+					t.logf("%v", err)
+				}
+			}
+		case 1:
+			// The client failed to read one of its files and carries on
+			t.errs++
+			t.logf("client error: %s", line[1:])
+			continue
+		case 2:
+			return fmt.Errorf("client error: %s", line[1:])
+		default:
+			fmt.Fprintf(t.w, "\x02scp: protocol error\n")
+			return fmt.Errorf("protocol error: unexpected line %q", line)
+		}
+
+		if err := t.ack(); err != nil {
+			return err
+		}
+	}
+}
+
+// scpFatalError is an error after which the protocol can't continue
+type scpFatalError struct {
+	err error
+}
+
+func (e *scpFatalError) Error() string {
+	return e.err.Error()
+}
+
+// enterDir creates dest for a "D" line unless it already exists
+func (t *scpTransfer) enterDir(dest string, mode fs.FileMode) error {
+	if !t.cmd.recursive {
+		return fmt.Errorf("%s: received directory without -r", dest)
+	}
+
+	info, err := t.fsys.Stat(dest)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s: Not a directory", dest)
+		}
+		if t.cmd.preserve {
+			return t.fsys.Chmod(dest, mode)
+		}
+		return nil
+	}
+	return t.fsys.Mkdir(dest, mode)
+}
+
+// receiveFile reads the contents following a "C" line into dest
+func (t *scpTransfer) receiveFile(dest string, mode fs.FileMode, size int64, times *scpTimes) error {
+	if t.maxSize > 0 && size > t.maxSize {
+		return fmt.Errorf("%s: file size %d exceeds the limit of %d bytes", dest, size, t.maxSize)
+	}
+
+	file, err := t.fsys.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if err := t.ack(); err != nil {
+		file.Close()
+		return &scpFatalError{err}
+	}
+
+	// Always consume the whole file so the protocol stays in sync even when
+	// writing fails
+	lr := &io.LimitedReader{R: t.r, N: size}
+	_, werr := io.Copy(file, lr)
+	if werr != nil {
+		if _, err := io.CopyN(io.Discard, t.r, lr.N); err != nil {
+			file.Close()
+			return &scpFatalError{err}
+		}
+	}
+	cerr := file.Close()
+
+	// The client follows the contents with its own status
+	if err := t.readAck(); err != nil {
+		var warning *scpWarning
+		if errors.As(err, &warning) {
+			return fmt.Errorf("%s: client error: %v", dest, err)
+		}
+		return &scpFatalError{err}
+	}
+
+	switch {
+	case werr != nil:
+		return werr
+	case cerr != nil:
+		return cerr
+	}
+
+	if t.cmd.preserve {
+		if err := t.fsys.Chmod(dest, mode); err != nil {
+			return err
+		}
+		if times != nil {
+			if err := t.fsys.Chtimes(dest, times.atime, times.mtime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// source sends the files matching paths
+func (t *scpTransfer) source(paths []string) error {
+	if err := t.readAck(); err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		name := fsName(p)
+		names := []string{name}
+		if strings.ContainsAny(name, "*?[") {
+			matches, err := fs.Glob(t.fsys, name)
+			if err == nil && len(matches) > 0 {
+				names = matches
+			}
+		}
+
+		for _, name := range names {
+			if err := t.send(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// send sends a file or, with -r, a directory tree
+func (t *scpTransfer) send(name string) error {
+	info, err := t.fsys.Stat(name)
+	if err != nil {
+		return t.warn(err)
+	}
+
+	if info.IsDir() {
+		if !t.cmd.recursive {
+			return t.warn(fmt.Errorf("%s: not a regular file", name))
+		}
+		return t.sendDir(name, info)
+	}
+	if !info.Mode().IsRegular() {
+		return t.warn(fmt.Errorf("%s: not a regular file", name))
+	}
+	return t.sendFile(name, info)
+}
+
+func (t *scpTransfer) sendTimes(info fs.FileInfo) error {
+	if !t.cmd.preserve {
+		return nil
+	}
+	mtime := info.ModTime().Unix()
+	if _, err := fmt.Fprintf(t.w, "T%d 0 %d 0\n", mtime, mtime); err != nil {
+		return err
+	}
+	return t.readAck()
+}
+
+func (t *scpTransfer) sendDir(name string, info fs.FileInfo) error {
+	entries, err := t.fsys.ReadDir(name)
+	if err != nil {
+		return t.warn(err)
+	}
+
+	if err := t.sendTimes(info); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(t.w, "D%04o 0 %s\n", unixMode(info.Mode())&07777, scpBase(name)); err != nil {
+		return err
+	}
+	if err := t.readAck(); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := t.send(path.Join(name, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(t.w, "E\n"); err != nil {
+		return err
+	}
+	return t.readAck()
+}
+
+func (t *scpTransfer) sendFile(name string, info fs.FileInfo) error {
+	file, err := t.fsys.Open(name)
+	if err != nil {
+		return t.warn(err)
+	}
+	defer file.Close()
+
+	if err := t.sendTimes(info); err != nil {
+		return err
+	}
+
+	size := info.Size()
+	if _, err := fmt.Fprintf(t.w, "C%04o %d %s\n", unixMode(info.Mode())&07777, size, scpBase(name)); err != nil {
+		return err
+	}
+	if err := t.readAck(); err != nil {
+		var warning *scpWarning
+		if errors.As(err, &warning) {
+			// The client couldn't create the file; skip it
+			t.errs++
+			return nil
+		}
+		return err
+	}
+
+	// The header promised size bytes; pad with zeros if the file shrank
+	n, rerr := io.Copy(t.w, io.LimitReader(file, size))
+	if n < size {
+		if _, err := io.CopyN(t.w, zeroReader{}, size-n); err != nil {
+			return err
+		}
+		if rerr == nil {
+			rerr = io.ErrUnexpectedEOF
+		}
+	}
+
+	if rerr != nil {
+		if err := t.warn(fmt.Errorf("%s: %v", name, rerr)); err != nil {
+			return err
+		}
+	} else if err := t.ack(); err != nil {
+		return err
+	}
+
+	if err := t.readAck(); err != nil {
+		var warning *scpWarning
+		if !errors.As(err, &warning) {
+			return err
+		}
+		t.errs++
+	}
+	return nil
+}
+
+// zeroReader returns an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// scpBase returns the name sent for name in "C" and "D" lines
+func scpBase(name string) string {
+	if name == "." {
+		return "."
+	}
+	return path.Base(name)
+}
+
+// parseSCPHeader parses the "<mode> <size> <name>" part of a "C" or "D" line
+func parseSCPHeader(s string) (fs.FileMode, int64, string, error) {
+	parts := strings.SplitN(s, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("invalid header %q", s)
+	}
+
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid mode %q", parts[0])
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("invalid size %q", parts[1])
+	}
+
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("invalid file name %q", name)
+	}
+
+	return fs.FileMode(mode) & fs.ModePerm, size, name, nil
+}
+
+// parseSCPTimes parses the "<mtime> 0 <atime> 0" part of a "T" line
+func parseSCPTimes(s string) (*scpTimes, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid times %q", s)
+	}
+
+	mtime, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modification time %q", parts[0])
+	}
+	atime, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access time %q", parts[2])
+	}
+
+	return &scpTimes{mtime: time.Unix(mtime, 0), atime: time.Unix(atime, 0)}, nil
+}
+
+// scpErrorText formats err the way scp clients print errors
+func scpErrorText(err error) string {
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		return err.Error()
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return pathErr.Path + ": No such file or directory"
+	case errors.Is(err, fs.ErrPermission):
+		return pathErr.Path + ": Permission denied"
+	default:
+		return pathErr.Path + ": " + pathErr.Err.Error()
+	}
+}
+
+// splitCommandLine splits a command line into words the way a POSIX shell
+// would, honoring single quotes, double quotes and backslash escapes
+func splitCommandLine(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
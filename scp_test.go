@@ -0,0 +1,172 @@
+package sshserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingFS is a FileSystem whose files named fail accept no writes
+type failingFS struct {
+	FileSystem
+	fail string
+}
+
+func (f failingFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	file, err := f.FileSystem.OpenFile(name, flag, perm)
+	if err != nil || name != f.fail {
+		return file, err
+	}
+	return failingFile{file}, nil
+}
+
+type failingFile struct {
+	File
+}
+
+func (failingFile) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func newTestDirFS(t *testing.T) (FileSystem, string) {
+	t.Helper()
+	dir := t.TempDir()
+	fsys, err := NewDirFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fsys.(*dirFS).Close() })
+	return fsys, dir
+}
+
+func TestSCPSinkWriteFailure(t *testing.T) {
+	fsys, dir := newTestDirFS(t)
+
+	// The first file is larger than io.Copy's buffer, so writing fails with
+	// most of its contents still unread
+	big := strings.Repeat("x", 100000)
+	input := "C0644 100000 a\n" + big + "\x00" +
+		"C0644 5 b\n" + "world" + "\x00"
+
+	var out bytes.Buffer
+	tr := &scpTransfer{
+		r:    bufio.NewReader(strings.NewReader(input)),
+		w:    &out,
+		fsys: failingFS{FileSystem: fsys, fail: "a"},
+		cmd:  &scpCommand{sink: true, paths: []string{"."}},
+		logf: func(string, ...interface{}) {},
+	}
+
+	if err := tr.sink("."); err != nil {
+		t.Fatalf("sink failed: %v", err)
+	}
+	if tr.errs != 1 {
+		t.Errorf("got %d errors, want 1", tr.errs)
+	}
+	if !strings.Contains(out.String(), "\x01scp: disk full\n") {
+		t.Errorf("write error not reported to client: %q", out.String())
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "b"))
+	if err != nil {
+		t.Fatalf("second file not written: %v", err)
+	}
+	if string(data) != "world" {
+		t.Errorf("second file holds %q, want %q", data, "world")
+	}
+}
+
+func TestSCPSinkDirectory(t *testing.T) {
+	fsys, dir := newTestDirFS(t)
+
+	input := "D0755 0 sub\n" +
+		"C0600 3 f\n" + "abc" + "\x00" +
+		"E\n"
+
+	var out bytes.Buffer
+	tr := &scpTransfer{
+		r:    bufio.NewReader(strings.NewReader(input)),
+		w:    &out,
+		fsys: fsys,
+		cmd:  &scpCommand{sink: true, recursive: true, paths: []string{"."}},
+		logf: func(string, ...interface{}) {},
+	}
+
+	if err := tr.sink("."); err != nil {
+		t.Fatalf("sink failed: %v", err)
+	}
+	if out.String() != strings.Repeat("\x00", 5) {
+		t.Errorf("unexpected replies %q", out.String())
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "sub", "f")); err != nil || string(data) != "abc" {
+		t.Errorf("got %q, %v", data, err)
+	}
+}
+
+func TestParseSCPHeader(t *testing.T) {
+	tests := []struct {
+		line string
+		mode fs.FileMode
+		size int64
+		name string
+		ok   bool
+	}{
+		{"0644 5 a.txt", 0644, 5, "a.txt", true},
+		{"0755 0 with space", 0755, 0, "with space", true},
+		{"104644 1 f", 0644, 1, "f", true},
+		{"0644 5", 0, 0, "", false},
+		{"0948 5 f", 0, 0, "", false},
+		{"0644 -1 f", 0, 0, "", false},
+		{"0644 5 ..", 0, 0, "", false},
+		{"0644 5 a/b", 0, 0, "", false},
+		{"0644 5 ", 0, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		mode, size, name, err := parseSCPHeader(tt.line)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseSCPHeader(%q) error = %v, want ok = %v", tt.line, err, tt.ok)
+			continue
+		}
+		if tt.ok && (mode != tt.mode || size != tt.size || name != tt.name) {
+			t.Errorf("parseSCPHeader(%q) = %o %d %q", tt.line, mode, size, name)
+		}
+	}
+}
+
+func TestParseSCPTimes(t *testing.T) {
+	times, err := parseSCPTimes("1700000000 0 1600000000 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if times.mtime.Unix() != 1700000000 || times.atime.Unix() != 1600000000 {
+		t.Errorf("got mtime %v atime %v", times.mtime, times.atime)
+	}
+
+	for _, s := range []string{"", "1 0 2", "x 0 1 0", "1 0 y 0"} {
+		if _, err := parseSCPTimes(s); err == nil {
+			t.Errorf("parseSCPTimes(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParseSCPCommand(t *testing.T) {
+	cmd, err := parseSCPCommand("scp -rpt -- 'my dir'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmd.sink || !cmd.recursive || !cmd.preserve || len(cmd.paths) != 1 || cmd.paths[0] != "my dir" {
+		t.Errorf("got %+v", cmd)
+	}
+
+	for _, s := range []string{"ls", "scp a", "scp -t -f a", "scp -t a b", "scp -x -t a", "scp -t"} {
+		if _, err := parseSCPCommand(s); err == nil {
+			t.Errorf("parseSCPCommand(%q) succeeded", s)
+		}
+	}
+}
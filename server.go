@@ -1,12 +1,14 @@
 package sshserver
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -23,13 +25,22 @@ type CommandHandler interface {
 
 // Server represents an SSH server instance
 type Server struct {
-	config        *Config
-	sshConfig     *ssh.ServerConfig
-	cmdHandler    CommandHandler
-	listener      net.Listener
-	done         chan struct{}
-	wg           sync.WaitGroup
-	logger       *log.Logger
+	config         *Config
+	sshConfig      *ssh.ServerConfig
+	cmdHandler     CommandHandler
+	handlerFactory HandlerFactory
+	historyStore   HistoryStore
+	keyStore       KeyStore
+	trustFiles     *trustFiles
+	userDirectory  UserDirectory
+	passwordAuth   PasswordAuthenticator
+	challengeFlow  ChallengeFlow
+	authenticator  Authenticator
+	subsystems     map[string]SubsystemHandler
+	listener       net.Listener
+	done           chan struct{}
+	wg             sync.WaitGroup
+	logger         *log.Logger
 }
 
 // NewServer creates a new SSH server instance
@@ -60,10 +71,23 @@ func NewServer(config *Config, handler CommandHandler) (*Server, error) {
 	s := &Server{
 		config:     config,
 		cmdHandler: handler,
+		subsystems: make(map[string]SubsystemHandler),
 		done:      make(chan struct{}),
 		logger:    log.New(logWriter, "", log.Ldate|log.Ltime|log.Lshortfile),
 	}
 
+	if config.HistoryDir != "" {
+		store, err := NewFileHistoryStore(config.HistoryDir)
+		if err != nil {
+			return nil, err
+		}
+		s.historyStore = store
+	}
+
+	if config.SFTP != nil {
+		s.subsystems["sftp"] = s.serveSFTP
+	}
+
 	sshConfig := &ssh.ServerConfig{
 		NoClientAuth: config.NoClientAuth,
 	}
@@ -75,21 +99,80 @@ func NewServer(config *Config, handler CommandHandler) (*Server, error) {
 		}
 		sshConfig.AddHostKey(private)
 
-		sshConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-			return s.validatePublicKey(conn, key)
+		if config.AuthorizedKeysFile != "" || config.AuthorizedKeysDir != "" {
+			store, err := NewFileKeyStore(config.AuthorizedKeysFile, config.AuthorizedKeysDir, s.logger)
+			if err != nil {
+				return nil, err
+			}
+			s.keyStore = store
+		}
+
+		if config.TrustedUserCAKeysFile != "" || config.RevokedKeysFile != "" {
+			files, err := newTrustFiles(config.TrustedUserCAKeysFile, config.RevokedKeysFile, s.logger)
+			if err != nil {
+				return nil, err
+			}
+			s.trustFiles = files
+		}
+
+		if config.UsersFile != "" {
+			s.userDirectory = NewFileUserDirectory(config.UsersFile)
+		}
+
+		if config.PasswordFile != "" {
+			s.passwordAuth = NewHtpasswdAuthenticator(config.PasswordFile)
 		}
 
-		if config.AllowKeyboardInteractive {
-			sshConfig.KeyboardInteractiveCallback = s.handleKeyboardInteractive
+		if config.AllowKeyboardInteractive && config.TOTPSecretsFile != "" {
+			s.challengeFlow = NewTOTPFlow(config.TOTPSecretsFile)
 		}
 	}
 
 	s.sshConfig = sshConfig
+	s.updateAuthCallbacks()
 	return s, nil
 }
 
+// SetHandlerFactory makes the server build a separate CommandHandler for every
+// SSH session instead of sharing the handler passed to NewServer
+func (s *Server) SetHandlerFactory(factory HandlerFactory) {
+	s.handlerFactory = factory
+}
+
+// SetHistoryStore sets where interactive shell history is loaded from and
+// saved to, replacing the file store configured with Config.HistoryDir
+func (s *Server) SetHistoryStore(store HistoryStore) {
+	s.historyStore = store
+}
+
+// SetKeyStore sets where authorized public keys are looked up, replacing the
+// files configured with Config.AuthorizedKeysFile and Config.AuthorizedKeysDir
+func (s *Server) SetKeyStore(store KeyStore) {
+	s.keyStore = store
+	s.updateAuthCallbacks()
+}
+
+// SetUserDirectory sets the accounts users log in as, replacing the file
+// configured with Config.UsersFile. A nil directory lets any user name log in.
+func (s *Server) SetUserDirectory(dir UserDirectory) {
+	s.userDirectory = dir
+}
+
+// SetPasswordAuthenticator enables password authentication using auth,
+// replacing the htpasswd file configured with Config.PasswordFile
+func (s *Server) SetPasswordAuthenticator(auth PasswordAuthenticator) {
+	s.passwordAuth = auth
+	s.updateAuthCallbacks()
+}
+
 // Start begins listening for SSH connections
 func (s *Server) Start() error {
+	// Methods may also be enabled after NewServer (e.g. SetAuthenticator),
+	// so this cannot be checked by Config.Validate
+	if !s.config.NoClientAuth && s.sshConfig.PublicKeyCallback == nil && s.sshConfig.PasswordCallback == nil && s.sshConfig.KeyboardInteractiveCallback == nil {
+		return fmt.Errorf("no authentication methods enabled")
+	}
+
 	listener, err := net.Listen("tcp", s.config.ListenAddress)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %v", s.config.ListenAddress, err)
@@ -101,6 +184,8 @@ func (s *Server) Start() error {
 	s.wg.Add(1)
 	go s.acceptConnections()
 
+	s.watchKeys()
+
 	return nil
 }
 
@@ -157,41 +242,143 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 	s.logger.Printf("Connection established from %s (user: %s)", sshConn.RemoteAddr(), sshConn.User())
 
-	go s.handleGlobalRequests(reqs)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.handleGlobalRequests(ctx, sshConn, reqs)
 
 	for newChannel := range chans {
-		if newChannel.ChannelType() != "session" {
+		switch newChannel.ChannelType() {
+		case "session":
+			s.acceptSession(ctx, sshConn, newChannel)
+		case "direct-tcpip":
+			if s.config.LocalForwarding == nil || !connPermitted(sshConn, permitPortForwarding) {
+				newChannel.Reject(ssh.Prohibited, "port forwarding is disabled")
+				continue
+			}
+			go s.handleDirectTCPIP(ctx, newChannel, sshConn)
+		case "direct-streamlocal@openssh.com":
+			if s.config.StreamLocalForwarding == nil || !connPermitted(sshConn, permitPortForwarding) {
+				newChannel.Reject(ssh.Prohibited, "socket forwarding is disabled")
+				continue
+			}
+			go s.handleDirectStreamLocal(ctx, newChannel, newSessionInfo(sshConn))
+		default:
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
-			continue
 		}
+	}
+}
 
-		channel, requests, err := newChannel.Accept()
-		if err != nil {
-			s.logger.Printf("Could not accept channel: %v", err)
-			continue
-		}
+// acceptSession accepts a "session" channel and serves its requests
+func (s *Server) acceptSession(ctx context.Context, conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		s.logger.Printf("Could not accept channel: %v", err)
+		return
+	}
+
+	sess := newSession(ctx, conn)
+	go s.handleChannel(channel, requests, s.newHandler(sess.SessionInfo), sess)
+}
 
-		go s.handleChannel(channel, requests)
+// newHandler returns the CommandHandler that serves the session described by info
+func (s *Server) newHandler(info SessionInfo) CommandHandler {
+	if s.handlerFactory != nil {
+		return s.handlerFactory(info)
 	}
+	return s.cmdHandler
 }
 
-func (s *Server) handleChannel(channel ssh.Channel, requests <-chan *ssh.Request) {
+func (s *Server) handleChannel(channel ssh.Channel, requests <-chan *ssh.Request, handler CommandHandler, sess *Session) {
 	defer channel.Close()
+	defer sess.close()
 
+	started := false
 	for req := range requests {
 		s.logger.Printf("Received channel request: %s", req.Type)
 
+		if sess.forceCommand != "" && (req.Type == "shell" || req.Type == "exec" || req.Type == "subsystem") {
+			run := s.execCommand(handler, sess, sess.forceCommand)
+			if started || run == nil {
+				req.Reply(false, nil)
+				continue
+			}
+
+			setOriginalCommand(sess, req)
+			s.logger.Printf("Running forced command for user %s instead of %s request", sess.User, req.Type)
+			started = true
+			req.Reply(true, nil)
+			go run(channel)
+			continue
+		}
+
 		switch req.Type {
 		case "pty-req":
+			if !permitted(sess.Extensions, permitPty) {
+				s.logger.Printf("Rejected pty request from user %s", sess.User)
+				req.Reply(false, nil)
+				continue
+			}
+			pty, err := parsePtyRequest(req.Payload)
+			if err != nil {
+				s.logger.Printf("Error parsing pty-req payload: %v", err)
+				req.Reply(false, nil)
+				continue
+			}
+			sess.setPty(pty)
 			req.Reply(true, nil)
+		case "window-change":
+			win, err := parseWindowChange(req.Payload)
+			if err != nil {
+				s.logger.Printf("Error parsing window-change payload: %v", err)
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(sess.setWindow(win), nil)
 		case "shell":
+			if started {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+			if handler != nil {
+				channel.Write([]byte(handler.GetWelcomeMessage() + "\n"))
+				go s.handleShell(channel, handler, sess)
+			}
+		case "env":
+			var payload struct{ Name, Value string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				s.logger.Printf("Error parsing env payload: %v", err)
+				req.Reply(false, nil)
+				continue
+			}
+			if _, fixed := keyEnvironment(sess.Extensions)[payload.Name]; fixed || !s.config.envAllowed(payload.Name) {
+				s.logger.Printf("Rejected environment variable %s from user %s", payload.Name, sess.User)
+				req.Reply(false, nil)
+				continue
+			}
+			sess.setEnv(payload.Name, payload.Value)
 			req.Reply(true, nil)
-			if s.cmdHandler != nil {
-				channel.Write([]byte(s.cmdHandler.GetWelcomeMessage() + "\n"))
-				go s.handleShell(channel)
+		case "auth-agent-req@openssh.com":
+			if !s.config.agentForwardingAllowed(sess.SessionInfo) || !permitted(sess.Extensions, permitAgentForwarding) {
+				s.logger.Printf("Rejected agent forwarding for user %s", sess.User)
+				req.Reply(false, nil)
+				continue
+			}
+			sess.setAgentForwarded()
+			req.Reply(true, nil)
+		case "signal":
+			var payload struct{ Signal string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				s.logger.Printf("Error parsing signal payload: %v", err)
+				req.Reply(false, nil)
+				continue
 			}
+			sess.signal(ssh.Signal(payload.Signal))
+			req.Reply(true, nil)
 		case "exec":
-			if s.cmdHandler == nil {
+			if started {
 				req.Reply(false, nil)
 				continue
 			}
@@ -203,103 +390,266 @@ func (s *Server) handleChannel(channel ssh.Channel, requests <-chan *ssh.Request
 				continue
 			}
 
-			output, exitStatus := s.cmdHandler.Execute(command)
-			channel.Write([]byte(output + "\n"))
+			run := s.execCommand(handler, sess, command)
+			if run == nil {
+				req.Reply(false, nil)
+				continue
+			}
+
+			started = true
 			req.Reply(true, nil)
-			sendExitStatus(channel, exitStatus)
-			return
+			go run(channel)
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				s.logger.Printf("Error parsing subsystem payload: %v", err)
+				req.Reply(false, nil)
+				continue
+			}
+			subsystem, ok := s.subsystems[payload.Name]
+			if started || !ok {
+				s.logger.Printf("Rejected subsystem %q from user %s", payload.Name, sess.User)
+				req.Reply(false, nil)
+				continue
+			}
+
+			started = true
+			req.Reply(true, nil)
+			go s.handleSubsystem(channel, sess, payload.Name, subsystem)
 		default:
 			req.Reply(false, nil)
 		}
 	}
 }
 
-func (s *Server) handleShell(channel ssh.Channel) {
+// execCommand returns the function running command for an "exec" request,
+// either as an scp transfer or through handler, or nil if the session cannot
+// run it
+func (s *Server) execCommand(handler CommandHandler, sess *Session, command string) func(ssh.Channel) {
+	if s.config.SCP != nil {
+		if scp, err := parseSCPCommand(command); err == nil {
+			return func(channel ssh.Channel) { s.handleSCP(channel, sess, scp) }
+		}
+	}
+
+	if handler == nil {
+		return nil
+	}
+	return func(channel ssh.Channel) { s.handleExec(channel, handler, sess, command) }
+}
+
+// setOriginalCommand records the command or subsystem replaced by a forced
+// command in SSH_ORIGINAL_COMMAND, as sshd does
+func setOriginalCommand(sess *Session, req *ssh.Request) {
+	switch req.Type {
+	case "exec":
+		if command, err := parseExecPayload(req.Payload); err == nil {
+			sess.setEnv("SSH_ORIGINAL_COMMAND", command)
+		}
+	case "subsystem":
+		var payload struct{ Name string }
+		if ssh.Unmarshal(req.Payload, &payload) == nil {
+			sess.setEnv("SSH_ORIGINAL_COMMAND", payload.Name)
+		}
+	}
+}
+
+// handleExec runs a single command requested with "exec" and closes the
+// channel once it completes
+func (s *Server) handleExec(channel ssh.Channel, handler CommandHandler, sess *Session, command string) {
+	defer channel.Close()
+
+	stdout, stderr := sessionOutput(channel, sess)
+
+	// Without a terminal there are no control keys to watch for, so the
+	// command reads the channel itself and keeps SSH flow control
+	if _, terminal := sess.Pty(); !terminal {
+		sess.beginCommand()
+		exitStatus := streamHandlerFor(handler).HandleStream(sess, command, channel, stdout, stderr)
+		sess.endCommand()
+		sendExitStatus(channel, exitStatus)
+		return
+	}
+
+	input := newChannelInput(channel)
+	defer input.stop()
+
+	exitStatus, _ := runCommand(sess, streamHandlerFor(handler), command, input, nil, stdout, stderr)
+	sendExitStatus(channel, exitStatus)
+}
+
+func (s *Server) handleShell(channel ssh.Channel, handler CommandHandler, sess *Session) {
 	defer channel.Close()
 
-	buffer := make([]byte, 1024)
-	var cmdBuffer []byte
+	stream := streamHandlerFor(handler)
+	stdout, stderr := &crlfWriter{channel}, &crlfWriter{channel.Stderr()}
+
+	input := newChannelInput(channel)
+	defer input.stop()
+
+	editor := newLineEditor(input, channel)
+	if pty, ok := sess.Pty(); ok {
+		editor.setWidth(int(pty.Width))
+	}
+	sess.notifyResize(func(win Window) {
+		editor.setWidth(int(win.Width))
+	})
+	if completer, ok := handler.(Completer); ok {
+		editor.completer = completer
+	}
+	if completer, ok := handler.(SessionCompleter); ok {
+		editor.completer = sessionCompleter{completer, sess}
+	}
 
-	// Send initial prompt
-	channel.Write([]byte(s.cmdHandler.GetPrompt()))
+	if s.historyStore != nil {
+		entries, err := s.historyStore.Load(sess.User)
+		if err != nil {
+			s.logger.Printf("Failed to load history for user %s: %v", sess.User, err)
+		}
+		editor.history.load(entries)
+	}
 
 	for {
-		n, err := channel.Read(buffer)
+		line, err := editor.readLine(handler.GetPrompt())
+		if err == errInterrupt {
+			continue
+		}
 		if err != nil {
 			if err != io.EOF {
 				s.logger.Printf("Error reading from channel: %v", err)
 			}
+			sendExitStatus(channel, 0)
 			return
 		}
 
-		for i := 0; i < n; i++ {
-			switch buffer[i] {
-			case '\r', '\n':
-				if len(cmdBuffer) > 0 {
-					cmd := string(cmdBuffer)
-					output, _ := s.cmdHandler.Execute(cmd)
-					channel.Write([]byte("\r\n" + output + "\r\n" + s.cmdHandler.GetPrompt()))
-					cmdBuffer = cmdBuffer[:0]
-				} else {
-					channel.Write([]byte("\r\n" + s.cmdHandler.GetPrompt()))
-				}
-			case 0x7f, 0x08: // Backspace
-				if len(cmdBuffer) > 0 {
-					cmdBuffer = cmdBuffer[:len(cmdBuffer)-1]
-					channel.Write([]byte{0x08, 0x20, 0x08}) // Backspace, space, backspace
-				}
-			default:
-				cmdBuffer = append(cmdBuffer, buffer[i])
-				channel.Write([]byte{buffer[i]})
+		if line == "" {
+			continue
+		}
+
+		if editor.history.add(line) && s.historyStore != nil {
+			if err := s.historyStore.Append(sess.User, line); err != nil {
+				s.logger.Printf("Failed to save history for user %s: %v", sess.User, err)
 			}
 		}
+
+		_, editor.pending = runCommand(sess, stream, line, input, editor.takePending(), stdout, stderr)
 	}
 }
 
-func (s *Server) handleGlobalRequests(reqs <-chan *ssh.Request) {
+func (s *Server) handleGlobalRequests(ctx context.Context, conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	forwards := &remoteForwards{listeners: make(map[string]net.Listener)}
+	defer forwards.close()
+
 	for req := range reqs {
 		s.logger.Printf("Received global request: %v", req.Type)
-		if req.WantReply {
-			req.Reply(false, nil)
+
+		switch req.Type {
+		case "tcpip-forward", "cancel-tcpip-forward":
+			var payload forwardRequest
+			if s.config.RemoteForwarding == nil || !connPermitted(conn, permitPortForwarding) || ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			if req.Type == "tcpip-forward" {
+				req.Reply(s.handleTCPIPForward(ctx, conn, forwards, payload))
+			} else {
+				req.Reply(s.cancelTCPIPForward(forwards, payload), nil)
+			}
+		case "streamlocal-forward@openssh.com", "cancel-streamlocal-forward@openssh.com":
+			var payload struct{ SocketPath string }
+			if s.config.StreamLocalForwarding == nil || !connPermitted(conn, permitPortForwarding) || ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			if req.Type == "streamlocal-forward@openssh.com" {
+				req.Reply(s.handleStreamLocalForward(ctx, conn, forwards, payload.SocketPath), nil)
+			} else {
+				req.Reply(s.cancelStreamLocalForward(forwards, payload.SocketPath), nil)
+			}
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
 		}
 	}
 }
 
 func (s *Server) validatePublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-	authorizedKeysBytes, err := os.ReadFile(s.config.AuthorizedKeysFile)
-	if err != nil {
-		s.logger.Printf("Failed to load authorized_keys: %v", err)
+	if err := s.checkRevoked(conn, key); err != nil {
 		return nil, err
 	}
+	return s.validateUnrevokedKey(conn, key)
+}
+
+// validateUnrevokedKey authenticates a key that passed checkRevoked
+func (s *Server) validateUnrevokedKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if s.config.TrustedUserCAKeysFile != "" {
+		return s.validateCertificate(conn, key)
+	}
+	return s.validateAuthorizedKey(conn, key)
+}
+
+// checkRevoked fails if key is listed in Config.RevokedKeysFile
+func (s *Server) checkRevoked(conn ssh.ConnMetadata, key ssh.PublicKey) error {
+	if s.trustFiles == nil {
+		return nil
+	}
+
+	if _, revoked := s.trustFiles.keys(); revoked != nil && revoked.keyRevoked(key) {
+		s.logger.Printf("Rejected revoked key %s for user %s", ssh.FingerprintSHA256(key), conn.User())
+		return fmt.Errorf("public key for %q is revoked", conn.User())
+	}
+	return nil
+}
 
+// validateAuthorizedKey accepts keys found in the key store
+func (s *Server) validateAuthorizedKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 	keyFingerprint := ssh.FingerprintSHA256(key)
 	s.logger.Printf("Attempting to authenticate user %s with key %s", conn.User(), keyFingerprint)
 
-	for len(authorizedKeysBytes) > 0 {
-		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
-		if err != nil {
-			s.logger.Printf("Error parsing authorized key: %v", err)
+	entries, err := s.keyStore.AuthorizedKeys(conn.User(), key)
+	if err != nil {
+		s.logger.Printf("Failed to look up authorized keys: %v", err)
+		return nil, err
+	}
+
+	var account *Account
+	if s.userDirectory != nil {
+		if account, err = s.lookupAccount(conn.User()); err != nil {
 			return nil, err
 		}
+	}
+
+	for _, entry := range entries {
+		if s.userDirectory != nil && !account.ownsKey(entry, keyFingerprint) {
+			s.logger.Printf("Key %s is not owned by account %s", keyFingerprint, conn.User())
+			continue
+		}
 
-		if ssh.FingerprintSHA256(pubKey) == keyFingerprint {
-			s.logger.Printf("Public key authentication successful for user: %s", conn.User())
-			return &ssh.Permissions{
-				Extensions: map[string]string{
-					"pubkey-fp": keyFingerprint,
-				},
-			}, nil
+		perms, err := keyOptionsPermissions(entry.Options, conn.RemoteAddr(), time.Now())
+		if err != nil {
+			s.logger.Printf("Key %s refused for user %s: %v", keyFingerprint, conn.User(), err)
+			continue
 		}
 
-		authorizedKeysBytes = rest
+		s.logger.Printf("Public key authentication successful for user: %s", conn.User())
+		perms.Extensions["pubkey-fp"] = keyFingerprint
+		return perms, nil
 	}
 
 	return nil, fmt.Errorf("public key authentication failed for %q", conn.User())
 }
 
-func (s *Server) handleKeyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
-	s.logger.Printf("Keyboard interactive auth attempt from user %s", conn.User())
-	return nil, fmt.Errorf("keyboard-interactive authentication not supported")
+func (s *Server) validatePassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	s.logger.Printf("Password auth attempt from user %s", conn.User())
+
+	if err := s.passwordAuth.AuthenticatePassword(conn.User(), password); err != nil {
+		s.logger.Printf("Password authentication failed: %v", err)
+		return nil, fmt.Errorf("password authentication failed for %q", conn.User())
+	}
+
+	s.logger.Printf("Password authentication successful for user: %s", conn.User())
+	return &ssh.Permissions{}, nil
 }
 
 func loadHostKey(keyFile string) (ssh.Signer, error) {
@@ -329,6 +679,15 @@ func parseExecPayload(payload []byte) (string, error) {
 	return string(payload[4 : 4+length]), nil
 }
 
+// sessionOutput returns the writers for a command's stdout and stderr,
+// translating line endings when the client has a terminal attached
+func sessionOutput(channel ssh.Channel, sess *Session) (io.Writer, io.Writer) {
+	if _, ok := sess.Pty(); ok {
+		return &crlfWriter{channel}, &crlfWriter{channel.Stderr()}
+	}
+	return channel, channel.Stderr()
+}
+
 func sendExitStatus(channel ssh.Channel, status uint32) {
 	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
 }
\ No newline at end of file
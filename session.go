@@ -0,0 +1,299 @@
+package sshserver
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes the authenticated client behind an SSH session
+type SessionInfo struct {
+	// User is the username the client authenticated as
+	User string
+
+	// RemoteAddr is the client's network address
+	RemoteAddr net.Addr
+
+	// LocalAddr is the server address the client connected to
+	LocalAddr net.Addr
+
+	// KeyFingerprint is the SHA256 fingerprint of the public key used to
+	// authenticate, or empty when another method was used
+	KeyFingerprint string
+
+	// Account is the account the user resolved to in the server's
+	// UserDirectory, or the groups and roles reported by its Authenticator;
+	// nil when neither provided one
+	Account *Account
+
+	// Attributes holds the attributes returned by the server's Authenticator
+	Attributes map[string]string
+}
+
+// HandlerFactory builds a CommandHandler for a single SSH session
+type HandlerFactory func(info SessionInfo) CommandHandler
+
+// SessionHandler is implemented by command handlers that need to know which
+// session a command comes from. When a handler implements it, the server calls
+// ExecuteSession instead of CommandHandler.Execute.
+type SessionHandler interface {
+	// ExecuteSession handles a command on behalf of sess and returns the
+	// output and exit status
+	ExecuteSession(sess *Session, cmd string) (string, uint32)
+}
+
+// Session carries the state of a single SSH session channel
+type Session struct {
+	SessionInfo
+
+	// Extensions holds the extensions recorded in ssh.Permissions during
+	// authentication (e.g. "pubkey-fp")
+	Extensions map[string]string
+
+	conn   ssh.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.RWMutex
+	env       map[string]string
+	pty       *PtyInfo
+	values    map[string]interface{}
+	cmdCtx    context.Context
+	cmdCancel context.CancelFunc
+	signals   chan ssh.Signal
+	windows   chan Window
+	onResize  []func(Window)
+
+	agentForwarded bool
+
+	// forceCommand replaces every command, shell or subsystem the client
+	// requests, from the force-command critical option
+	forceCommand string
+}
+
+// newSession creates the session state for a channel opened on conn. The
+// session context is derived from ctx and cancelled when the session ends.
+func newSession(ctx context.Context, conn *ssh.ServerConn) *Session {
+	sess := &Session{
+		SessionInfo: newSessionInfo(conn),
+		Extensions:  make(map[string]string),
+		conn:        conn,
+		env:         make(map[string]string),
+		values:      make(map[string]interface{}),
+		signals:     make(chan ssh.Signal, 8),
+		windows:     make(chan Window, 1),
+	}
+
+	if conn.Permissions != nil {
+		for k, v := range conn.Permissions.Extensions {
+			sess.Extensions[k] = v
+		}
+		sess.forceCommand = conn.Permissions.CriticalOptions[forceCommandOption]
+		for k, v := range keyEnvironment(conn.Permissions.Extensions) {
+			sess.env[k] = v
+		}
+	}
+
+	sess.ctx, sess.cancel = context.WithCancel(ctx)
+	return sess
+}
+
+// Context returns a context that is cancelled when the client disconnects or
+// the session channel is closed. While a command is running, the returned
+// context is also cancelled when the command is interrupted with Ctrl-C or an
+// INT, TERM, HUP or KILL signal request.
+func (s *Session) Context() context.Context {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.cmdCtx != nil {
+		return s.cmdCtx
+	}
+	return s.ctx
+}
+
+// Signals returns the channel on which signals sent to the running command
+// are delivered, either from SSH "signal" requests or from Ctrl-C in a terminal
+func (s *Session) Signals() <-chan ssh.Signal {
+	return s.signals
+}
+
+// Getenv returns the value of an environment variable sent by the client
+func (s *Session) Getenv(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.env[key]
+}
+
+// Environ returns a copy of the environment variables sent by the client
+func (s *Session) Environ() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	env := make(map[string]string, len(s.env))
+	for k, v := range s.env {
+		env[k] = v
+	}
+	return env
+}
+
+// Pty returns the pseudo-terminal details and whether a PTY was requested
+func (s *Session) Pty() (PtyInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.pty == nil {
+		return PtyInfo{}, false
+	}
+	return *s.pty, true
+}
+
+// WindowChanges returns a channel that receives the new terminal size each
+// time the client resizes its window. Only the latest size is kept when the
+// handler doesn't keep up.
+func (s *Session) WindowChanges() <-chan Window {
+	return s.windows
+}
+
+// Get returns a value previously stored on the session with Set
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set stores a value on the session for the lifetime of the session
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// Delete removes a value stored on the session
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+func (s *Session) setEnv(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.env[key] = value
+}
+
+func (s *Session) setPty(pty PtyInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pty = &pty
+}
+
+// setWindow records a new terminal size and notifies listeners. It reports
+// false, changing nothing, when the session has no terminal: a PTY would turn
+// on terminal output handling such as "\n" to "\r\n" for plain exec output.
+func (s *Session) setWindow(win Window) bool {
+	s.mu.Lock()
+	if s.pty == nil {
+		s.mu.Unlock()
+		return false
+	}
+	s.pty.Window = win
+	hooks := s.onResize
+	s.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(win)
+	}
+
+	select {
+	case <-s.windows:
+	default:
+	}
+	s.windows <- win
+	return true
+}
+
+// notifyResize registers an internal callback run on every window change
+func (s *Session) notifyResize(hook func(Window)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onResize = append(s.onResize, hook)
+}
+
+// beginCommand gives the command about to run its own cancellable context
+func (s *Session) beginCommand() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cmdCtx, s.cmdCancel = context.WithCancel(s.ctx)
+
+	// Drop signals left over from a previous command
+	for len(s.signals) > 0 {
+		<-s.signals
+	}
+}
+
+// endCommand releases the context of the command that just finished
+func (s *Session) endCommand() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmdCancel != nil {
+		s.cmdCancel()
+	}
+	s.cmdCtx, s.cmdCancel = nil, nil
+}
+
+// signal delivers sig to the running command, cancelling its context for
+// signals that normally terminate a process
+func (s *Session) signal(sig ssh.Signal) {
+	select {
+	case s.signals <- sig:
+	default:
+		// Nobody is listening; don't block the request loop
+	}
+
+	switch sig {
+	case ssh.SIGINT, ssh.SIGTERM, ssh.SIGHUP, ssh.SIGKILL:
+		s.mu.RLock()
+		cancel := s.cmdCancel
+		s.mu.RUnlock()
+		if cancel != nil {
+			cancel()
+		}
+	}
+}
+
+// close cancels the session context
+func (s *Session) close() {
+	s.cancel()
+}
+
+// executeCommand runs cmd through handler, passing the session along when the
+// handler implements SessionHandler
+func executeCommand(handler CommandHandler, sess *Session, cmd string) (string, uint32) {
+	if sh, ok := handler.(SessionHandler); ok {
+		return sh.ExecuteSession(sess, cmd)
+	}
+	return handler.Execute(cmd)
+}
+
+// newSessionInfo collects the session details from an established connection
+func newSessionInfo(conn *ssh.ServerConn) SessionInfo {
+	info := SessionInfo{
+		User:       conn.User(),
+		RemoteAddr: conn.RemoteAddr(),
+		LocalAddr:  conn.LocalAddr(),
+	}
+
+	if conn.Permissions != nil {
+		info.KeyFingerprint = conn.Permissions.Extensions["pubkey-fp"]
+		info.Account = accountFromPermissions(conn.Permissions)
+		info.Attributes = attributesFromPermissions(conn.Permissions)
+	}
+
+	return info
+}
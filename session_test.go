@@ -0,0 +1,104 @@
+package sshserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestClient connects a client logged in as alice to s over loopback. The
+// connection gets perms, and the sessions the server accepts are sent on the
+// returned channel, which holds up to 16 of them.
+func newTestClient(t *testing.T, s *Server, perms *ssh.Permissions) (*ssh.Client, <-chan *Session) {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return perms, nil
+		},
+	}
+	config.AddHostKey(newTestSigner(t))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	sessions := make(chan *Session, 16)
+	go func() {
+		nConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn, chans, reqs, err := ssh.NewServerConn(nConn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			sess := newSession(context.Background(), conn)
+			select {
+			case sessions <- sess:
+			default:
+			}
+			go s.handleChannel(channel, requests, s.newHandler(sess.SessionInfo), sess)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, sessions
+}
+
+func TestSetWindowWithoutPty(t *testing.T) {
+	sess := newTestSession(false)
+	resized := false
+	sess.notifyResize(func(Window) { resized = true })
+
+	if sess.setWindow(Window{Width: 100, Height: 40}) {
+		t.Error("window change accepted without a pty")
+	}
+	if _, ok := sess.Pty(); ok {
+		t.Error("window change allocated a pty")
+	}
+	if resized || len(sess.WindowChanges()) != 0 {
+		t.Error("window change without a pty was delivered")
+	}
+}
+
+func TestSetWindow(t *testing.T) {
+	sess := newTestSession(true)
+	var hooked Window
+	sess.notifyResize(func(win Window) { hooked = win })
+
+	for _, win := range []Window{{Width: 100, Height: 40}, {Width: 120, Height: 50}} {
+		if !sess.setWindow(win) {
+			t.Fatal("window change refused")
+		}
+	}
+
+	want := Window{Width: 120, Height: 50}
+	if pty, _ := sess.Pty(); pty.Window != want || pty.Term != "xterm" {
+		t.Errorf("got pty %+v", pty)
+	}
+	if hooked != want {
+		t.Errorf("resize hook got %+v", hooked)
+	}
+	// Only the latest size is kept for the handler
+	if win := <-sess.WindowChanges(); win != want || len(sess.WindowChanges()) != 0 {
+		t.Errorf("got %+v", win)
+	}
+}
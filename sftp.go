@@ -0,0 +1,823 @@
+package sshserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTP protocol version 3 (draft-ietf-secsh-filexfer-02), the version
+// implemented by OpenSSH
+const sftpProtocolVersion = 3
+
+const (
+	// sftpMaxPacket bounds incoming packets; OpenSSH clients send at most
+	// 256 KiB of data per write
+	sftpMaxPacket = 256*1024 + 1024
+
+	// sftpMaxRead bounds the data returned by a single read request
+	sftpMaxRead = 64 * 1024
+
+	// sftpDirBatch is the number of entries returned per readdir request
+	sftpDirBatch = 100
+
+	// sftpMaxHandles bounds the files and directories a session can have
+	// open at once, like the descriptor limit of OpenSSH's sftp-server
+	sftpMaxHandles = 512
+)
+
+// SFTP packet types
+const (
+	sshFxpInit          = 1
+	sshFxpVersion       = 2
+	sshFxpOpen          = 3
+	sshFxpClose         = 4
+	sshFxpRead          = 5
+	sshFxpWrite         = 6
+	sshFxpLstat         = 7
+	sshFxpFstat         = 8
+	sshFxpSetstat       = 9
+	sshFxpFsetstat      = 10
+	sshFxpOpendir       = 11
+	sshFxpReaddir       = 12
+	sshFxpRemove        = 13
+	sshFxpMkdir         = 14
+	sshFxpRmdir         = 15
+	sshFxpRealpath      = 16
+	sshFxpStat          = 17
+	sshFxpRename        = 18
+	sshFxpReadlink      = 19
+	sshFxpSymlink       = 20
+	sshFxpStatus        = 101
+	sshFxpHandle        = 102
+	sshFxpData          = 103
+	sshFxpName          = 104
+	sshFxpAttrs         = 105
+	sshFxpExtended      = 200
+	sshFxpExtendedReply = 201
+)
+
+// SFTP status codes
+const (
+	sshFxOk               = 0
+	sshFxEOF              = 1
+	sshFxNoSuchFile       = 2
+	sshFxPermissionDenied = 3
+	sshFxFailure          = 4
+	sshFxBadMessage       = 5
+	sshFxOpUnsupported    = 8
+)
+
+// SFTP open flags
+const (
+	sshFxfRead   = 0x01
+	sshFxfWrite  = 0x02
+	sshFxfAppend = 0x04
+	sshFxfCreat  = 0x08
+	sshFxfTrunc  = 0x10
+	sshFxfExcl   = 0x20
+)
+
+// SFTP attribute flags
+const (
+	sshFileXferAttrSize        = 0x01
+	sshFileXferAttrUIDGID      = 0x02
+	sshFileXferAttrPermissions = 0x04
+	sshFileXferAttrACModTime   = 0x08
+	sshFileXferAttrExtended    = 0x80000000
+)
+
+// errShortPacket is returned when a packet ends before all fields are read
+var errShortPacket = errors.New("sftp: packet too short")
+
+// sftpAttrs holds the file attributes carried by SFTP requests and replies
+type sftpAttrs struct {
+	flags uint32
+	size  uint64
+	uid   uint32
+	gid   uint32
+	perm  uint32
+	atime uint32
+	mtime uint32
+}
+
+// sftpHandle is an open file or directory
+type sftpHandle struct {
+	name    string
+	file    File
+	append  bool
+	entries []fs.DirEntry
+	isDir   bool
+}
+
+// sftpServer serves the SFTP protocol for one session
+type sftpServer struct {
+	rw      io.ReadWriter
+	fsys    FileSystem
+	owner   string
+	handles map[string]*sftpHandle
+	nextID  uint64
+}
+
+func newSFTPServer(rw io.ReadWriter, fsys FileSystem, owner string) *sftpServer {
+	return &sftpServer{
+		rw:      rw,
+		fsys:    fsys,
+		owner:   owner,
+		handles: make(map[string]*sftpHandle),
+	}
+}
+
+// serve processes requests until the client closes the channel
+func (srv *sftpServer) serve() error {
+	defer srv.closeHandles()
+
+	for {
+		packet, err := srv.readPacket()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if err := srv.handlePacket(packet); err != nil {
+			return err
+		}
+	}
+}
+
+func (srv *sftpServer) readPacket() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(srv.rw, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length == 0 || length > sftpMaxPacket {
+		return nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(srv.rw, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func (srv *sftpServer) writePacket(e *sftpEncoder) error {
+	packet := make([]byte, 4, 4+len(e.b))
+	binary.BigEndian.PutUint32(packet, uint32(len(e.b)))
+	_, err := srv.rw.Write(append(packet, e.b...))
+	return err
+}
+
+func (srv *sftpServer) handlePacket(packet []byte) error {
+	d := &sftpDecoder{b: packet[1:]}
+	typ := packet[0]
+
+	if typ == sshFxpInit {
+		e := newSFTPEncoder(sshFxpVersion)
+		e.putUint32(sftpProtocolVersion)
+		e.putString("posix-rename@openssh.com")
+		e.putString("1")
+		return srv.writePacket(e)
+	}
+
+	id := d.getUint32()
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	switch typ {
+	case sshFxpOpen:
+		return srv.handleOpen(id, d)
+	case sshFxpClose:
+		return srv.handleClose(id, d)
+	case sshFxpRead:
+		return srv.handleRead(id, d)
+	case sshFxpWrite:
+		return srv.handleWrite(id, d)
+	case sshFxpLstat, sshFxpStat:
+		return srv.handleStat(id, d)
+	case sshFxpFstat:
+		return srv.handleFstat(id, d)
+	case sshFxpSetstat:
+		return srv.handleSetstat(id, d)
+	case sshFxpFsetstat:
+		return srv.handleFsetstat(id, d)
+	case sshFxpOpendir:
+		return srv.handleOpendir(id, d)
+	case sshFxpReaddir:
+		return srv.handleReaddir(id, d)
+	case sshFxpRemove:
+		return srv.handleRemove(id, d)
+	case sshFxpMkdir:
+		return srv.handleMkdir(id, d)
+	case sshFxpRmdir:
+		return srv.handleRmdir(id, d)
+	case sshFxpRealpath:
+		return srv.handleRealpath(id, d)
+	case sshFxpRename:
+		return srv.handleRename(id, d, false)
+	case sshFxpExtended:
+		if d.getString() == "posix-rename@openssh.com" {
+			return srv.handleRename(id, d, true)
+		}
+		return srv.writeStatus(id, sshFxOpUnsupported, "unsupported extension")
+	default:
+		// Including READLINK and SYMLINK: FileSystem has no symbolic links
+		return srv.writeStatus(id, sshFxOpUnsupported, "operation not supported")
+	}
+}
+
+func (srv *sftpServer) handleOpen(id uint32, d *sftpDecoder) error {
+	name := fsName(d.getString())
+	pflags := d.getUint32()
+	attrs := d.getAttrs()
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	var flag int
+	switch {
+	case pflags&sshFxfRead != 0 && pflags&sshFxfWrite != 0:
+		flag = os.O_RDWR
+	case pflags&sshFxfWrite != 0:
+		flag = os.O_WRONLY
+	default:
+		flag = os.O_RDONLY
+	}
+	if pflags&sshFxfAppend != 0 {
+		flag |= os.O_APPEND
+	}
+	if pflags&sshFxfCreat != 0 {
+		flag |= os.O_CREATE
+	}
+	if pflags&sshFxfTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+	if pflags&sshFxfExcl != 0 {
+		flag |= os.O_EXCL
+	}
+
+	perm := fs.FileMode(0644)
+	if attrs.flags&sshFileXferAttrPermissions != 0 {
+		perm = fs.FileMode(attrs.perm & 0777)
+	}
+
+	if len(srv.handles) >= sftpMaxHandles {
+		return srv.writeStatus(id, sshFxFailure, "too many open handles")
+	}
+	file, err := srv.fsys.OpenFile(name, flag, perm)
+	if err != nil {
+		return srv.writeError(id, err)
+	}
+
+	return srv.writeHandle(id, &sftpHandle{
+		name:   name,
+		file:   file,
+		append: pflags&sshFxfAppend != 0,
+	})
+}
+
+func (srv *sftpServer) handleClose(id uint32, d *sftpDecoder) error {
+	key := d.getString()
+	h, ok := srv.handles[key]
+	if !ok {
+		return srv.writeStatus(id, sshFxFailure, "invalid handle")
+	}
+	delete(srv.handles, key)
+
+	if h.file != nil {
+		if err := h.file.Close(); err != nil {
+			return srv.writeError(id, err)
+		}
+	}
+	return srv.writeStatus(id, sshFxOk, "")
+}
+
+func (srv *sftpServer) handleRead(id uint32, d *sftpDecoder) error {
+	h := srv.handles[d.getString()]
+	offset := d.getUint64()
+	length := d.getUint32()
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+	if h == nil || h.file == nil {
+		return srv.writeStatus(id, sshFxFailure, "invalid handle")
+	}
+
+	if length > sftpMaxRead {
+		length = sftpMaxRead
+	}
+
+	buf := make([]byte, length)
+	n, err := h.file.ReadAt(buf, int64(offset))
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			return srv.writeStatus(id, sshFxEOF, "EOF")
+		}
+		return srv.writeError(id, err)
+	}
+
+	e := newSFTPEncoder(sshFxpData)
+	e.putUint32(id)
+	e.putBytes(buf[:n])
+	return srv.writePacket(e)
+}
+
+func (srv *sftpServer) handleWrite(id uint32, d *sftpDecoder) error {
+	h := srv.handles[d.getString()]
+	offset := d.getUint64()
+	data := d.getBytes()
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+	if h == nil || h.file == nil {
+		return srv.writeStatus(id, sshFxFailure, "invalid handle")
+	}
+
+	var err error
+	if h.append {
+		_, err = h.file.Write(data)
+	} else {
+		_, err = h.file.WriteAt(data, int64(offset))
+	}
+	if err != nil {
+		return srv.writeError(id, err)
+	}
+	return srv.writeStatus(id, sshFxOk, "")
+}
+
+func (srv *sftpServer) handleStat(id uint32, d *sftpDecoder) error {
+	name := fsName(d.getString())
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	info, err := srv.fsys.Stat(name)
+	if err != nil {
+		return srv.writeError(id, err)
+	}
+	return srv.writeAttrs(id, info)
+}
+
+func (srv *sftpServer) handleFstat(id uint32, d *sftpDecoder) error {
+	h := srv.handles[d.getString()]
+	if h == nil {
+		return srv.writeStatus(id, sshFxFailure, "invalid handle")
+	}
+
+	var info fs.FileInfo
+	var err error
+	if h.file != nil {
+		info, err = h.file.Stat()
+	} else {
+		info, err = srv.fsys.Stat(h.name)
+	}
+	if err != nil {
+		return srv.writeError(id, err)
+	}
+	return srv.writeAttrs(id, info)
+}
+
+func (srv *sftpServer) handleSetstat(id uint32, d *sftpDecoder) error {
+	name := fsName(d.getString())
+	attrs := d.getAttrs()
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	if attrs.flags&sshFileXferAttrSize != 0 {
+		file, err := srv.fsys.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return srv.writeError(id, err)
+		}
+		err = truncateFile(file, int64(attrs.size))
+		file.Close()
+		if err != nil {
+			return srv.writeError(id, err)
+		}
+	}
+
+	return srv.writeError(id, srv.applyAttrs(name, attrs))
+}
+
+func (srv *sftpServer) handleFsetstat(id uint32, d *sftpDecoder) error {
+	h := srv.handles[d.getString()]
+	attrs := d.getAttrs()
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+	if h == nil {
+		return srv.writeStatus(id, sshFxFailure, "invalid handle")
+	}
+
+	if attrs.flags&sshFileXferAttrSize != 0 {
+		if h.file == nil {
+			return srv.writeStatus(id, sshFxFailure, "cannot truncate a directory")
+		}
+		if err := truncateFile(h.file, int64(attrs.size)); err != nil {
+			return srv.writeError(id, err)
+		}
+	}
+
+	return srv.writeError(id, srv.applyAttrs(h.name, attrs))
+}
+
+// applyAttrs changes permissions and times; ownership changes are ignored
+func (srv *sftpServer) applyAttrs(name string, attrs sftpAttrs) error {
+	if attrs.flags&sshFileXferAttrPermissions != 0 {
+		if err := srv.fsys.Chmod(name, fs.FileMode(attrs.perm&0777)); err != nil {
+			return err
+		}
+	}
+
+	if attrs.flags&sshFileXferAttrACModTime != 0 {
+		atime := time.Unix(int64(attrs.atime), 0)
+		mtime := time.Unix(int64(attrs.mtime), 0)
+		if err := srv.fsys.Chtimes(name, atime, mtime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (srv *sftpServer) handleOpendir(id uint32, d *sftpDecoder) error {
+	name := fsName(d.getString())
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	if len(srv.handles) >= sftpMaxHandles {
+		return srv.writeStatus(id, sshFxFailure, "too many open handles")
+	}
+	entries, err := srv.fsys.ReadDir(name)
+	if err != nil {
+		return srv.writeError(id, err)
+	}
+
+	return srv.writeHandle(id, &sftpHandle{
+		name:    name,
+		entries: entries,
+		isDir:   true,
+	})
+}
+
+func (srv *sftpServer) handleReaddir(id uint32, d *sftpDecoder) error {
+	h := srv.handles[d.getString()]
+	if h == nil || !h.isDir {
+		return srv.writeStatus(id, sshFxFailure, "invalid handle")
+	}
+
+	var infos []fs.FileInfo
+	for len(h.entries) > 0 && len(infos) < sftpDirBatch {
+		entry := h.entries[0]
+		h.entries = h.entries[1:]
+
+		info, err := entry.Info()
+		if err != nil {
+			// The entry disappeared since the directory was read
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	if len(infos) == 0 {
+		return srv.writeStatus(id, sshFxEOF, "EOF")
+	}
+
+	e := newSFTPEncoder(sshFxpName)
+	e.putUint32(id)
+	e.putUint32(uint32(len(infos)))
+	for _, info := range infos {
+		e.putString(info.Name())
+		e.putString(srv.longName(info))
+		e.putAttrs(info)
+	}
+	return srv.writePacket(e)
+}
+
+func (srv *sftpServer) handleRemove(id uint32, d *sftpDecoder) error {
+	name := fsName(d.getString())
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	info, err := srv.fsys.Stat(name)
+	if err != nil {
+		return srv.writeError(id, err)
+	}
+	if info.IsDir() {
+		return srv.writeStatus(id, sshFxFailure, "is a directory")
+	}
+	return srv.writeError(id, srv.fsys.Remove(name))
+}
+
+func (srv *sftpServer) handleMkdir(id uint32, d *sftpDecoder) error {
+	name := fsName(d.getString())
+	attrs := d.getAttrs()
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	perm := fs.FileMode(0755)
+	if attrs.flags&sshFileXferAttrPermissions != 0 {
+		perm = fs.FileMode(attrs.perm & 0777)
+	}
+	return srv.writeError(id, srv.fsys.Mkdir(name, perm))
+}
+
+func (srv *sftpServer) handleRmdir(id uint32, d *sftpDecoder) error {
+	name := fsName(d.getString())
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	info, err := srv.fsys.Stat(name)
+	if err != nil {
+		return srv.writeError(id, err)
+	}
+	if !info.IsDir() {
+		return srv.writeStatus(id, sshFxFailure, "not a directory")
+	}
+	return srv.writeError(id, srv.fsys.Remove(name))
+}
+
+func (srv *sftpServer) handleRealpath(id uint32, d *sftpDecoder) error {
+	name := fsName(d.getString())
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	p := "/"
+	if name != "." {
+		p += name
+	}
+
+	e := newSFTPEncoder(sshFxpName)
+	e.putUint32(id)
+	e.putUint32(1)
+	e.putString(p)
+	e.putString(p)
+	e.putUint32(0)
+	return srv.writePacket(e)
+}
+
+// handleRename renames a file. Plain SFTP renames refuse to replace an
+// existing file; the posix-rename extension replaces it atomically.
+func (srv *sftpServer) handleRename(id uint32, d *sftpDecoder, replace bool) error {
+	oldname := fsName(d.getString())
+	newname := fsName(d.getString())
+	if d.err != nil {
+		return srv.writeStatus(id, sshFxBadMessage, d.err.Error())
+	}
+
+	if !replace {
+		if _, err := srv.fsys.Stat(newname); err == nil {
+			return srv.writeStatus(id, sshFxFailure, "target already exists")
+		}
+	}
+	return srv.writeError(id, srv.fsys.Rename(oldname, newname))
+}
+
+func (srv *sftpServer) writeHandle(id uint32, h *sftpHandle) error {
+	srv.nextID++
+	key := strconv.FormatUint(srv.nextID, 10)
+	srv.handles[key] = h
+
+	e := newSFTPEncoder(sshFxpHandle)
+	e.putUint32(id)
+	e.putString(key)
+	return srv.writePacket(e)
+}
+
+func (srv *sftpServer) writeAttrs(id uint32, info fs.FileInfo) error {
+	e := newSFTPEncoder(sshFxpAttrs)
+	e.putUint32(id)
+	e.putAttrs(info)
+	return srv.writePacket(e)
+}
+
+// writeError replies with the status matching err, or OK when err is nil
+func (srv *sftpServer) writeError(id uint32, err error) error {
+	switch {
+	case err == nil:
+		return srv.writeStatus(id, sshFxOk, "")
+	case errors.Is(err, fs.ErrNotExist):
+		return srv.writeStatus(id, sshFxNoSuchFile, "no such file")
+	case errors.Is(err, fs.ErrPermission):
+		return srv.writeStatus(id, sshFxPermissionDenied, "permission denied")
+	default:
+		return srv.writeStatus(id, sshFxFailure, err.Error())
+	}
+}
+
+func (srv *sftpServer) writeStatus(id uint32, code uint32, msg string) error {
+	e := newSFTPEncoder(sshFxpStatus)
+	e.putUint32(id)
+	e.putUint32(code)
+	e.putString(msg)
+	e.putString("")
+	return srv.writePacket(e)
+}
+
+// longName formats info like "ls -l" for READDIR replies
+func (srv *sftpServer) longName(info fs.FileInfo) string {
+	mode := info.Mode().String()
+	if info.Mode()&fs.ModeSymlink != 0 {
+		mode = "l" + mode[1:]
+	}
+
+	modTime := info.ModTime()
+	layout := "Jan _2 15:04"
+	if time.Since(modTime) > 180*24*time.Hour || modTime.After(time.Now()) {
+		layout = "Jan _2  2006"
+	}
+
+	return fmt.Sprintf("%s %4d %-8s %-8s %8d %s %s",
+		mode, 1, srv.owner, srv.owner, info.Size(), modTime.Format(layout), info.Name())
+}
+
+func (srv *sftpServer) closeHandles() {
+	for key, h := range srv.handles {
+		if h.file != nil {
+			h.file.Close()
+		}
+		delete(srv.handles, key)
+	}
+}
+
+// serveSFTP is the SubsystemHandler for "sftp" when Config.SFTP is set
+func (s *Server) serveSFTP(sess *Session, channel ssh.Channel) uint32 {
+	cfg := s.config.SFTP
+	fsys, err := openFileSystem(sess, cfg.Root, cfg.PerUserRoot, cfg.ReadOnly, cfg.FileSystem)
+	if err != nil {
+		s.logger.Printf("Failed to open sftp filesystem for user %s: %v", sess.User, err)
+		return 1
+	}
+	if c, ok := fsys.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if err := newSFTPServer(channel, fsys, sess.User).serve(); err != nil {
+		s.logger.Printf("SFTP session for user %s failed: %v", sess.User, err)
+		return 1
+	}
+	return 0
+}
+
+// truncateFile changes the size of file when the FileSystem supports it
+func truncateFile(file File, size int64) error {
+	t, ok := file.(interface{ Truncate(int64) error })
+	if !ok {
+		return fmt.Errorf("truncate not supported")
+	}
+	return t.Truncate(size)
+}
+
+// unixMode converts a fs.FileMode into the POSIX mode bits SFTP clients expect
+func unixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 01000
+	}
+
+	switch {
+	case m.IsDir():
+		mode |= 0040000
+	case m&fs.ModeSymlink != 0:
+		mode |= 0120000
+	case m&fs.ModeNamedPipe != 0:
+		mode |= 0010000
+	case m&fs.ModeSocket != 0:
+		mode |= 0140000
+	case m&fs.ModeCharDevice != 0:
+		mode |= 0020000
+	case m&fs.ModeDevice != 0:
+		mode |= 0060000
+	default:
+		mode |= 0100000
+	}
+	return mode
+}
+
+// sftpEncoder builds an SFTP packet body
+type sftpEncoder struct {
+	b []byte
+}
+
+func newSFTPEncoder(typ byte) *sftpEncoder {
+	return &sftpEncoder{b: []byte{typ}}
+}
+
+func (e *sftpEncoder) putUint32(v uint32) {
+	e.b = binary.BigEndian.AppendUint32(e.b, v)
+}
+
+func (e *sftpEncoder) putUint64(v uint64) {
+	e.b = binary.BigEndian.AppendUint64(e.b, v)
+}
+
+func (e *sftpEncoder) putString(s string) {
+	e.putUint32(uint32(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *sftpEncoder) putBytes(b []byte) {
+	e.putUint32(uint32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *sftpEncoder) putAttrs(info fs.FileInfo) {
+	e.putUint32(sshFileXferAttrSize | sshFileXferAttrPermissions | sshFileXferAttrACModTime)
+	e.putUint64(uint64(info.Size()))
+	e.putUint32(unixMode(info.Mode()))
+	mtime := uint32(info.ModTime().Unix())
+	e.putUint32(mtime)
+	e.putUint32(mtime)
+}
+
+// sftpDecoder reads fields from an SFTP packet body. The first error is
+// recorded in err and later reads return zero values.
+type sftpDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *sftpDecoder) getUint32() uint32 {
+	if d.err != nil || len(d.b) < 4 {
+		d.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *sftpDecoder) getUint64() uint64 {
+	if d.err != nil || len(d.b) < 8 {
+		d.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.b)
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *sftpDecoder) getBytes() []byte {
+	n := d.getUint32()
+	if d.err != nil || uint32(len(d.b)) < n {
+		d.err = errShortPacket
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *sftpDecoder) getString() string {
+	return string(d.getBytes())
+}
+
+func (d *sftpDecoder) getAttrs() sftpAttrs {
+	var a sftpAttrs
+	a.flags = d.getUint32()
+	if a.flags&sshFileXferAttrSize != 0 {
+		a.size = d.getUint64()
+	}
+	if a.flags&sshFileXferAttrUIDGID != 0 {
+		a.uid = d.getUint32()
+		a.gid = d.getUint32()
+	}
+	if a.flags&sshFileXferAttrPermissions != 0 {
+		a.perm = d.getUint32()
+	}
+	if a.flags&sshFileXferAttrACModTime != 0 {
+		a.atime = d.getUint32()
+		a.mtime = d.getUint32()
+	}
+	if a.flags&sshFileXferAttrExtended != 0 {
+		count := d.getUint32()
+		for i := uint32(0); i < count && d.err == nil; i++ {
+			d.getString()
+			d.getString()
+		}
+	}
+	return a
+}
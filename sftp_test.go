@@ -0,0 +1,222 @@
+package sshserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSFTPDecoder(t *testing.T) {
+	e := newSFTPEncoder(0)
+	e.putUint32(7)
+	e.putString("name")
+	e.putUint32(sshFileXferAttrSize | sshFileXferAttrUIDGID | sshFileXferAttrPermissions | sshFileXferAttrACModTime | sshFileXferAttrExtended)
+	e.putUint64(1 << 40)
+	e.putUint32(1000)
+	e.putUint32(100)
+	e.putUint32(0100644)
+	e.putUint32(1600000000)
+	e.putUint32(1700000000)
+	e.putUint32(1)
+	e.putString("ext@example.com")
+	e.putString("value")
+	e.putUint32(42)
+
+	d := &sftpDecoder{b: e.b[1:]}
+	id, name, attrs, last := d.getUint32(), d.getString(), d.getAttrs(), d.getUint32()
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+	want := sftpAttrs{
+		flags: sshFileXferAttrSize | sshFileXferAttrUIDGID | sshFileXferAttrPermissions | sshFileXferAttrACModTime | sshFileXferAttrExtended,
+		size:  1 << 40, uid: 1000, gid: 100, perm: 0100644, atime: 1600000000, mtime: 1700000000,
+	}
+	if id != 7 || name != "name" || attrs != want || last != 42 {
+		t.Errorf("got %d %q %+v %d", id, name, attrs, last)
+	}
+
+	// Every prefix of the packet is too short
+	for n := 0; n < len(e.b)-1; n++ {
+		d := &sftpDecoder{b: e.b[1 : 1+n]}
+		d.getUint32()
+		d.getString()
+		d.getAttrs()
+		d.getUint32()
+		if d.err != errShortPacket {
+			t.Errorf("%d bytes: got %v", n, d.err)
+		}
+	}
+
+	// A string length beyond the packet is not trusted
+	d = &sftpDecoder{b: []byte{0xff, 0xff, 0xff, 0xff, 'x'}}
+	if s := d.getString(); s != "" || d.err != errShortPacket {
+		t.Errorf("got %q, %v", s, d.err)
+	}
+}
+
+// sftpPipe feeds scripted requests to an sftpServer and collects its replies
+type sftpPipe struct {
+	io.Reader
+	io.Writer
+}
+
+func sftpRequest(typ byte, id uint32, fields ...interface{}) []byte {
+	e := newSFTPEncoder(typ)
+	e.putUint32(id)
+	for _, f := range fields {
+		switch v := f.(type) {
+		case string:
+			e.putString(v)
+		case uint32:
+			e.putUint32(v)
+		case uint64:
+			e.putUint64(v)
+		}
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(e.b)))
+	return append(packet, e.b...)
+}
+
+// readReplies splits the server output into packet bodies
+func readReplies(t *testing.T, out []byte) [][]byte {
+	t.Helper()
+	var replies [][]byte
+	for len(out) > 0 {
+		if len(out) < 4 {
+			t.Fatalf("truncated reply")
+		}
+		n := binary.BigEndian.Uint32(out)
+		replies = append(replies, out[4:4+n])
+		out = out[4+n:]
+	}
+	return replies
+}
+
+func TestSFTPSession(t *testing.T) {
+	fsys, dir := newTestDirFS(t)
+	if err := os.WriteFile(filepath.Join(dir, "old"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var in bytes.Buffer
+	init := newSFTPEncoder(sshFxpInit)
+	init.putUint32(sftpProtocolVersion)
+	in.Write(binary.BigEndian.AppendUint32(nil, uint32(len(init.b))))
+	in.Write(init.b)
+	in.Write(sftpRequest(sshFxpOpen, 1, "/new", uint32(sshFxfWrite|sshFxfCreat|sshFxfTrunc), uint32(sshFileXferAttrPermissions), uint32(0600)))
+	in.Write(sftpRequest(sshFxpWrite, 2, "1", uint64(0), "hello"))
+	in.Write(sftpRequest(sshFxpClose, 3, "1"))
+	in.Write(sftpRequest(sshFxpRename, 4, "new", "old"))
+	in.Write(sftpRequest(sshFxpExtended, 5, "posix-rename@openssh.com", "new", "old"))
+	in.Write(sftpRequest(sshFxpStat, 6, "/old"))
+	in.Write(sftpRequest(sshFxpStat, 7, "../../etc/passwd"))
+	in.Write(sftpRequest(sshFxpOpen, 8))
+	in.Write(sftpRequest(sshFxpSymlink, 9, "a", "b"))
+
+	var out bytes.Buffer
+	if err := newSFTPServer(sftpPipe{&in, &out}, fsys, "alice").serve(); err != nil {
+		t.Fatal(err)
+	}
+	replies := readReplies(t, out.Bytes())
+	if len(replies) != 10 {
+		t.Fatalf("got %d replies, want 10", len(replies))
+	}
+
+	if replies[0][0] != sshFxpVersion || binary.BigEndian.Uint32(replies[0][1:]) != sftpProtocolVersion {
+		t.Errorf("bad version reply % x", replies[0])
+	}
+	if d := (&sftpDecoder{b: replies[1][1:]}); replies[1][0] != sshFxpHandle || d.getUint32() != 1 || d.getString() != "1" {
+		t.Errorf("bad handle reply % x", replies[1])
+	}
+
+	status := func(i int) uint32 {
+		d := &sftpDecoder{b: replies[i][1:]}
+		if replies[i][0] != sshFxpStatus || d.getUint32() != uint32(i) {
+			t.Errorf("reply %d is not a status for request %d: % x", i, i, replies[i])
+		}
+		return d.getUint32()
+	}
+	for i, want := range map[int]uint32{
+		2: sshFxOk,
+		3: sshFxOk,
+		4: sshFxFailure, // plain renames do not replace
+		5: sshFxOk,
+		7: sshFxNoSuchFile,
+		8: sshFxBadMessage,
+		9: sshFxOpUnsupported,
+	} {
+		if got := status(i); got != want {
+			t.Errorf("request %d: got status %d, want %d", i, got, want)
+		}
+	}
+
+	d := &sftpDecoder{b: replies[6][1:]}
+	if replies[6][0] != sshFxpAttrs || d.getUint32() != 6 {
+		t.Fatalf("bad attrs reply % x", replies[6])
+	}
+	if attrs := d.getAttrs(); attrs.size != 5 || attrs.perm != 0100600 {
+		t.Errorf("got attrs %+v", attrs)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "old")); err != nil || string(data) != "hello" {
+		t.Errorf("got %q, %v", data, err)
+	}
+}
+
+func TestSFTPReadPacketLimits(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":     {0, 0, 0, 0},
+		"too large": binary.BigEndian.AppendUint32(nil, sftpMaxPacket+1),
+		"truncated": {0, 0, 0, 5, 1},
+	} {
+		srv := newSFTPServer(sftpPipe{bytes.NewReader(data), io.Discard}, nil, "")
+		if _, err := srv.readPacket(); err == nil {
+			t.Errorf("%s: readPacket succeeded", name)
+		}
+	}
+}
+
+func TestSFTPHandleLimit(t *testing.T) {
+	fsys, _ := newTestDirFS(t)
+
+	var in bytes.Buffer
+	for i := 1; i <= sftpMaxHandles; i++ {
+		in.Write(sftpRequest(sshFxpOpendir, uint32(i), "/"))
+	}
+	in.Write(sftpRequest(sshFxpOpendir, sftpMaxHandles+1, "/"))
+	in.Write(sftpRequest(sshFxpOpen, sftpMaxHandles+2, "/new", uint32(sshFxfWrite|sshFxfCreat), uint32(0)))
+	in.Write(sftpRequest(sshFxpClose, sftpMaxHandles+3, "1"))
+	in.Write(sftpRequest(sshFxpOpendir, sftpMaxHandles+4, "/"))
+
+	var out bytes.Buffer
+	if err := newSFTPServer(sftpPipe{&in, &out}, fsys, "alice").serve(); err != nil {
+		t.Fatal(err)
+	}
+
+	replies := readReplies(t, out.Bytes())
+	if len(replies) != sftpMaxHandles+4 {
+		t.Fatalf("got %d replies, want %d", len(replies), sftpMaxHandles+4)
+	}
+	for i, want := range map[int]byte{
+		sftpMaxHandles - 1: sshFxpHandle,
+		sftpMaxHandles:     sshFxpStatus,
+		sftpMaxHandles + 1: sshFxpStatus,
+		sftpMaxHandles + 2: sshFxpStatus,
+		sftpMaxHandles + 3: sshFxpHandle,
+	} {
+		if replies[i][0] != want {
+			t.Errorf("reply %d: got type %d, want %d", i, replies[i][0], want)
+		}
+	}
+	for _, i := range []int{sftpMaxHandles, sftpMaxHandles + 1} {
+		d := &sftpDecoder{b: replies[i][1:]}
+		if d.getUint32(); d.getUint32() != sshFxFailure {
+			t.Errorf("reply %d: want SSH_FX_FAILURE, got % x", i, replies[i])
+		}
+	}
+	if _, err := fsys.Stat("new"); err == nil {
+		t.Errorf("a file was created past the handle limit")
+	}
+}
@@ -0,0 +1,56 @@
+package sshserver
+
+import (
+	"io"
+	"strings"
+)
+
+// StreamHandler is implemented by command handlers that need direct access to
+// the session's input and output, e.g. to stream output from long-running
+// commands or to read data sent by the client. When a handler implements it,
+// both exec requests and shell commands are routed through HandleStream.
+type StreamHandler interface {
+	// HandleStream runs cmd for sess, reading input from stdin and writing
+	// output to stdout and stderr, and returns the exit status
+	HandleStream(sess *Session, cmd string, stdin io.Reader, stdout, stderr io.Writer) uint32
+}
+
+// commandStreamAdapter runs a plain CommandHandler as a StreamHandler
+type commandStreamAdapter struct {
+	handler CommandHandler
+}
+
+// NewStreamAdapter returns a StreamHandler that runs commands through handler
+// and writes the collected output to stdout once the command completes
+func NewStreamAdapter(handler CommandHandler) StreamHandler {
+	return &commandStreamAdapter{handler: handler}
+}
+
+// HandleStream implements StreamHandler.HandleStream
+func (a *commandStreamAdapter) HandleStream(sess *Session, cmd string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+	output, exitStatus := executeCommand(a.handler, sess, cmd)
+	io.WriteString(stdout, output+"\n")
+	return exitStatus
+}
+
+// streamHandlerFor returns handler as a StreamHandler, adapting it when it
+// only implements CommandHandler
+func streamHandlerFor(handler CommandHandler) StreamHandler {
+	if sh, ok := handler.(StreamHandler); ok {
+		return sh
+	}
+	return NewStreamAdapter(handler)
+}
+
+// crlfWriter translates bare "\n" into "\r\n" for clients attached to a terminal
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	s := strings.ReplaceAll(string(p), "\r\n", "\n")
+	if _, err := io.WriteString(c.w, strings.ReplaceAll(s, "\n", "\r\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,94 @@
+package sshserver
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// streamLocalAllowed reports whether the user may connect to or, if listen is
+// set, listen on the Unix socket at socketPath
+func (s *Server) streamLocalAllowed(info SessionInfo, socketPath string, listen bool) bool {
+	cfg := s.config.StreamLocalForwarding
+	if !filepath.IsAbs(socketPath) || !cfg.pathAllowed(filepath.Clean(socketPath)) {
+		return false
+	}
+	return cfg.Allow == nil || cfg.Allow(info, filepath.Clean(socketPath), listen)
+}
+
+// handleDirectStreamLocal serves a "direct-streamlocal@openssh.com" channel
+// opened by "ssh -L" with a Unix socket destination
+func (s *Server) handleDirectStreamLocal(ctx context.Context, newChannel ssh.NewChannel, info SessionInfo) {
+	var payload struct {
+		SocketPath string
+		Reserved0  string
+		Reserved1  uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		s.logger.Printf("Error parsing direct-streamlocal payload: %v", err)
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	if !s.streamLocalAllowed(info, payload.SocketPath, false) {
+		s.logger.Printf("Denied forwarding to socket %s for user %s", payload.SocketPath, info.User)
+		newChannel.Reject(ssh.Prohibited, "socket forwarding denied")
+		return
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", filepath.Clean(payload.SocketPath))
+	if err != nil {
+		s.logger.Printf("Failed to connect to socket %s for user %s: %v", payload.SocketPath, info.User, err)
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		s.logger.Printf("Could not accept channel: %v", err)
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	s.logger.Printf("Forwarding connection to socket %s for user %s", payload.SocketPath, info.User)
+	proxy(ctx, channel, conn)
+}
+
+// handleStreamLocalForward listens on a Unix socket for "ssh -R" and
+// forwards the connections it accepts back to the client
+func (s *Server) handleStreamLocalForward(ctx context.Context, conn *ssh.ServerConn, forwards *remoteForwards, socketPath string) bool {
+	info := newSessionInfo(conn)
+	if !s.streamLocalAllowed(info, socketPath, true) {
+		s.logger.Printf("Denied listening on socket %s for user %s", socketPath, info.User)
+		return false
+	}
+
+	listener, err := net.Listen("unix", filepath.Clean(socketPath))
+	if err != nil {
+		s.logger.Printf("Failed to listen on socket %s for user %s: %v", socketPath, info.User, err)
+		return false
+	}
+
+	if !forwards.add("unix:"+filepath.Clean(socketPath), listener) {
+		listener.Close()
+		return false
+	}
+
+	s.logger.Printf("Forwarding connections on socket %s to user %s", socketPath, info.User)
+	go s.acceptForwarded(ctx, conn, listener, "forwarded-streamlocal@openssh.com", func(net.Conn) []byte {
+		return ssh.Marshal(struct {
+			SocketPath string
+			Reserved   string
+		}{socketPath, ""})
+	})
+	return true
+}
+
+// cancelStreamLocalForward closes the listener opened for socketPath
+func (s *Server) cancelStreamLocalForward(forwards *remoteForwards, socketPath string) bool {
+	return forwards.remove("unix:" + filepath.Clean(socketPath))
+}
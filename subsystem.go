@@ -0,0 +1,32 @@
+package sshserver
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// SubsystemHandler serves a subsystem such as "sftp" or "netconf". It reads
+// and writes the protocol directly on the session channel and returns the
+// exit status sent to the client; the channel is closed when it returns.
+type SubsystemHandler func(sess *Session, channel ssh.Channel) uint32
+
+// RegisterSubsystem makes the server accept "subsystem" requests for name,
+// replacing any handler registered before, including the built-in "sftp"
+// subsystem. A nil handler removes the subsystem. Call it before Start.
+func (s *Server) RegisterSubsystem(name string, handler SubsystemHandler) {
+	if handler == nil {
+		delete(s.subsystems, name)
+		return
+	}
+	s.subsystems[name] = handler
+}
+
+// handleSubsystem runs handler for an accepted subsystem request and closes
+// the channel once it returns
+func (s *Server) handleSubsystem(channel ssh.Channel, sess *Session, name string, handler SubsystemHandler) {
+	defer channel.Close()
+
+	s.logger.Printf("Starting subsystem %s for user %s", name, sess.User)
+	status := handler(sess, channel)
+	s.logger.Printf("Subsystem %s for user %s exited with status %d", name, sess.User, status)
+	sendExitStatus(channel, status)
+}
@@ -0,0 +1,105 @@
+package sshserver
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newSubsystemServer returns a server with a "greet" subsystem that writes a
+// greeting
+func newSubsystemServer() *Server {
+	s := &Server{
+		config:     &Config{},
+		subsystems: make(map[string]SubsystemHandler),
+		logger:     log.New(&bytes.Buffer{}, "", 0),
+	}
+	s.RegisterSubsystem("greet", func(sess *Session, channel ssh.Channel) uint32 {
+		io.WriteString(channel, "hello "+sess.User)
+		return 0
+	})
+	return s
+}
+
+func TestRegisterSubsystem(t *testing.T) {
+	s := newSubsystemServer()
+	client, _ := newTestClient(t, s, nil)
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.RequestSubsystem("greet"); err != nil {
+		t.Fatal(err)
+	}
+	// The channel is closed when the handler returns
+	if out, err := io.ReadAll(stdout); err != nil || string(out) != "hello alice" {
+		t.Errorf("got %q, %v", out, err)
+	}
+}
+
+func TestRegisterSubsystemReplace(t *testing.T) {
+	s := newSubsystemServer()
+	s.RegisterSubsystem("greet", func(sess *Session, channel ssh.Channel) uint32 {
+		io.WriteString(channel, "replaced")
+		return 0
+	})
+	client, _ := newTestClient(t, s, nil)
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.RequestSubsystem("greet"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := io.ReadAll(stdout); err != nil || string(out) != "replaced" {
+		t.Errorf("got %q, %v", out, err)
+	}
+}
+
+func TestUnknownSubsystem(t *testing.T) {
+	s := newSubsystemServer()
+	s.RegisterSubsystem("removed", func(*Session, ssh.Channel) uint32 { return 0 })
+	s.RegisterSubsystem("removed", nil)
+	client, _ := newTestClient(t, s, nil)
+
+	for _, name := range []string{"sftp", "netconf", "removed", "Greet", ""} {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := session.RequestSubsystem(name); err == nil {
+			t.Errorf("subsystem %q accepted", name)
+		}
+		session.Close()
+	}
+
+	// A rejected request leaves the session free for a registered one
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.RequestSubsystem("netconf"); err == nil {
+		t.Fatal("subsystem netconf accepted")
+	}
+	if err := session.RequestSubsystem("greet"); err != nil {
+		t.Errorf("greet after a rejected request: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package sshserver
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// totpPeriod is the lifetime of a code, as used by authenticator apps
+	totpPeriod = 30
+	// totpDigits is the length of a code
+	totpDigits = 6
+	// totpSkew is the number of periods either side of now that are
+	// accepted, allowing for clock drift and slow typing
+	totpSkew = 1
+)
+
+// TOTPFlow is a ChallengeFlow asking for a time-based one-time password
+// (RFC 6238) from an authenticator app. Secrets are read from a file with one
+// "user:BASE32SECRET" entry per line; blank lines and lines starting with '#'
+// are ignored. The file is read on every attempt, and each code can be used
+// only once.
+type TOTPFlow struct {
+	path string
+
+	mu       sync.Mutex
+	lastUsed map[string]int64
+}
+
+// NewTOTPFlow creates a TOTPFlow reading secrets from path
+func NewTOTPFlow(path string) *TOTPFlow {
+	return &TOTPFlow{
+		path:     path,
+		lastUsed: make(map[string]int64),
+	}
+}
+
+// Challenges implements ChallengeFlow
+func (t *TOTPFlow) Challenges(user string) ([]Challenge, error) {
+	return []Challenge{{
+		Questions: []Question{{Prompt: "Verification code: ", Echo: false}},
+		Validate: func(user string, answers []string) error {
+			return t.Verify(user, answers[0])
+		},
+	}}, nil
+}
+
+// Verify checks a code for user. A code that was already accepted is
+// rejected to prevent replays.
+func (t *TOTPFlow) Verify(user, code string) error {
+	secret, err := t.lookup(user)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("no TOTP secret for %q", user)
+	}
+
+	code = strings.TrimSpace(code)
+	now := time.Now().Unix() / totpPeriod
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for counter := now - totpSkew; counter <= now+totpSkew; counter++ {
+		expected := totpCode(secret, counter)
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) != 1 {
+			continue
+		}
+		if counter <= t.lastUsed[user] {
+			return fmt.Errorf("TOTP code for %q was already used", user)
+		}
+		t.lastUsed[user] = counter
+		return nil
+	}
+
+	return fmt.Errorf("wrong TOTP code for %q", user)
+}
+
+// lookup returns the decoded secret for user, or nil if there is none
+func (t *TOTPFlow) lookup(user string) ([]byte, error) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TOTP secrets file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, encoded, ok := strings.Cut(line, ":")
+		if !ok || name != user {
+			continue
+		}
+
+		secret, err := decodeTOTPSecret(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOTP secret for %q: %v", user, err)
+		}
+		return secret, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read TOTP secrets file: %v", err)
+	}
+
+	return nil, nil
+}
+
+// GenerateTOTPSecret returns a new random secret, base32-encoded for the
+// secrets file and authenticator apps
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// decodeTOTPSecret decodes a base32 secret, ignoring case, spaces and padding
+func decodeTOTPSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	s = strings.TrimRight(s, "=")
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("empty secret")
+	}
+	return secret, nil
+}
+
+// totpCode computes the HOTP value (RFC 4226) of secret for counter
+func totpCode(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
@@ -0,0 +1,110 @@
+package sshserver
+
+import (
+	"encoding/base32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B (SHA-1), truncated to six digits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(secret, tt.time/totpPeriod); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	want := "12345678901234567890"
+	encoded := base32.StdEncoding.EncodeToString([]byte(want))
+	for _, s := range []string{encoded, "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ"} {
+		got, err := decodeTOTPSecret(s)
+		if err != nil || string(got) != want {
+			t.Errorf("decodeTOTPSecret(%q) = %q, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "====", "not base32!"} {
+		if _, err := decodeTOTPSecret(s); err == nil {
+			t.Errorf("decodeTOTPSecret(%q) succeeded", s)
+		}
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	encoded, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "totp")
+	data := "# secrets\nbob:not-base32!\nalice:" + encoded + "\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := decodeTOTPSecret(encoded)
+	now := time.Now().Unix() / totpPeriod
+
+	flow := NewTOTPFlow(path)
+	if err := flow.Verify("alice", "000000x"); err == nil {
+		t.Error("wrong code accepted")
+	}
+	if err := flow.Verify("alice", totpCode(secret, now-totpSkew-1)); err == nil {
+		t.Error("expired code accepted")
+	}
+	if err := flow.Verify("alice", totpCode(secret, now-totpSkew)); err != nil {
+		t.Errorf("code from the previous period rejected: %v", err)
+	}
+	if err := flow.Verify("alice", " "+totpCode(secret, now)+"\n"); err != nil {
+		t.Errorf("current code rejected: %v", err)
+	}
+	if err := flow.Verify("alice", totpCode(secret, now)); err == nil {
+		t.Error("replayed code accepted")
+	}
+	if err := flow.Verify("alice", totpCode(secret, now-totpSkew)); err == nil {
+		t.Error("code older than the last accepted one was accepted")
+	}
+
+	if err := flow.Verify("carol", totpCode(secret, now)); err == nil {
+		t.Error("user without a secret accepted")
+	}
+	if err := flow.Verify("bob", "123456"); err == nil {
+		t.Error("user with an invalid secret accepted")
+	}
+	if err := NewTOTPFlow(filepath.Join(t.TempDir(), "missing")).Verify("alice", totpCode(secret, now)); err == nil {
+		t.Error("missing secrets file accepted")
+	}
+}
+
+func TestTOTPChallenges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "totp")
+	if err := os.WriteFile(path, []byte("alice:GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	flow := NewTOTPFlow(path)
+
+	challenges, err := flow.Challenges("alice")
+	if err != nil || len(challenges) != 1 || len(challenges[0].Questions) != 1 {
+		t.Fatalf("got %+v, %v", challenges, err)
+	}
+	if challenges[0].Questions[0].Echo {
+		t.Error("codes are echoed")
+	}
+	code := totpCode([]byte("12345678901234567890"), time.Now().Unix()/totpPeriod)
+	if err := challenges[0].Validate("alice", []string{code}); err != nil {
+		t.Errorf("valid code rejected: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package sshserver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Extensions recording the account a client authenticated as
+const (
+	accountExtension       = "account"
+	accountGroupsExtension = "account-groups"
+	accountRolesExtension  = "account-roles"
+)
+
+// Account is a named user known to a UserDirectory
+type Account struct {
+	Name   string
+	Groups []string
+	Roles  []string
+
+	// Keys are the SHA256 fingerprints (e.g. "SHA256:...") of the keys in the
+	// shared authorized_keys file that belong to this account
+	Keys []string
+}
+
+// HasRole reports whether the account has role
+func (a *Account) HasRole(role string) bool {
+	return a != nil && containsString(a.Roles, role)
+}
+
+// InGroup reports whether the account belongs to group
+func (a *Account) InGroup(group string) bool {
+	return a != nil && containsString(a.Groups, group)
+}
+
+// UserDirectory resolves SSH user names to accounts. When the server has a
+// directory, only its accounts can log in, and public keys must be owned by
+// the account they are used for.
+type UserDirectory interface {
+	// LookupAccount returns the account named name, or nil if there is none
+	LookupAccount(name string) (*Account, error)
+}
+
+// FileUserDirectory is a UserDirectory read from a file with one
+// "name:groups:roles[:keys]" entry per line, where groups, roles and key
+// fingerprints are comma-separated lists that may be empty (e.g.
+// "alice:ops,dev:admin:SHA256:2a6F..."). Blank lines and lines starting with
+// '#' are ignored. The file is read on every lookup, so changes take effect
+// immediately.
+type FileUserDirectory struct {
+	path string
+}
+
+// NewFileUserDirectory creates a UserDirectory reading accounts from path
+func NewFileUserDirectory(path string) *FileUserDirectory {
+	return &FileUserDirectory{path: path}
+}
+
+// LookupAccount implements UserDirectory
+func (d *FileUserDirectory) LookupAccount(name string) (*Account, error) {
+	file, err := os.Open(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open users file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Fingerprints contain ':', so they take the rest of the line
+		fields := strings.SplitN(line, ":", 4)
+		if fields[0] != name {
+			continue
+		}
+
+		account := &Account{Name: name}
+		if len(fields) > 1 {
+			account.Groups = splitList(fields[1])
+		}
+		if len(fields) > 2 {
+			account.Roles = splitList(fields[2])
+		}
+		if len(fields) > 3 {
+			account.Keys = splitList(fields[3])
+		}
+		return account, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read users file: %v", err)
+	}
+
+	return nil, nil
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// lookupAccount returns the account of user, failing when the server has a
+// user directory and user is not in it
+func (s *Server) lookupAccount(user string) (*Account, error) {
+	if s.userDirectory == nil {
+		return nil, nil
+	}
+
+	account, err := s.userDirectory.LookupAccount(user)
+	if err != nil {
+		s.logger.Printf("Failed to look up account %s: %v", user, err)
+		return nil, err
+	}
+	if account == nil {
+		s.logger.Printf("Rejected login for unknown account %s", user)
+		return nil, fmt.Errorf("unknown account %q", user)
+	}
+	return account, nil
+}
+
+// ownsKey reports whether an authorized key belongs to the account: keys
+// with an owner recorded by the key store (e.g. from AuthorizedKeysDir)
+// belong to that owner, other keys to the accounts listing their fingerprint.
+// Key comments are never trusted.
+func (a *Account) ownsKey(entry AuthorizedKey, fingerprint string) bool {
+	if a == nil {
+		return false
+	}
+	if entry.Owner != "" {
+		return entry.Owner == a.Name
+	}
+	return containsString(a.Keys, fingerprint)
+}
+
+// withAccount records account in the extensions of perms
+func withAccount(perms *ssh.Permissions, account *Account) *ssh.Permissions {
+	if account == nil {
+		return perms
+	}
+	if perms == nil {
+		perms = &ssh.Permissions{}
+	}
+	if perms.Extensions == nil {
+		perms.Extensions = make(map[string]string)
+	}
+
+	perms.Extensions[accountExtension] = account.Name
+	perms.Extensions[accountGroupsExtension] = strings.Join(account.Groups, ",")
+	perms.Extensions[accountRolesExtension] = strings.Join(account.Roles, ",")
+	return perms
+}
+
+// accountFromPermissions returns the account recorded by withAccount, or nil
+func accountFromPermissions(perms *ssh.Permissions) *Account {
+	if perms == nil {
+		return nil
+	}
+	name, ok := perms.Extensions[accountExtension]
+	if !ok {
+		return nil
+	}
+
+	return &Account{
+		Name:   name,
+		Groups: splitList(perms.Extensions[accountGroupsExtension]),
+		Roles:  splitList(perms.Extensions[accountRolesExtension]),
+	}
+}
@@ -0,0 +1,96 @@
+package sshserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestFileUserDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	data := "# accounts\n" +
+		"alice:admins,ops:admin,deploy:SHA256:abc,SHA256:def\n" +
+		"\n" +
+		"carol::viewer\n" +
+		"erin\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := NewFileUserDirectory(path)
+
+	tests := []struct {
+		name string
+		want *Account
+	}{
+		{"alice", &Account{Name: "alice", Groups: []string{"admins", "ops"}, Roles: []string{"admin", "deploy"}, Keys: []string{"SHA256:abc", "SHA256:def"}}},
+		{"carol", &Account{Name: "carol", Roles: []string{"viewer"}}},
+		{"erin", &Account{Name: "erin"}},
+		{"mallory", nil},
+	}
+	for _, tt := range tests {
+		got, err := dir.LookupAccount(tt.name)
+		if err != nil {
+			t.Fatalf("LookupAccount(%q): %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LookupAccount(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAccountOwnsKey(t *testing.T) {
+	key := newTestPublicKey(t)
+	fp := ssh.FingerprintSHA256(key)
+	alice := &Account{Name: "alice", Keys: []string{fp}}
+	bob := &Account{Name: "bob"}
+
+	tests := []struct {
+		account *Account
+		entry   AuthorizedKey
+		want    bool
+	}{
+		// Shared keys belong to the accounts listing their fingerprint,
+		// whatever the comment says
+		{alice, AuthorizedKey{Key: key, Comment: "laptop key"}, true},
+		{bob, AuthorizedKey{Key: key, Comment: "bob@laptop"}, false},
+		{bob, AuthorizedKey{Key: key}, false},
+		// Keys from per-user files belong to their owner
+		{bob, AuthorizedKey{Key: key, Owner: "bob"}, true},
+		{alice, AuthorizedKey{Key: key, Owner: "bob"}, false},
+		{nil, AuthorizedKey{Key: key}, false},
+	}
+	for i, tt := range tests {
+		if got := tt.account.ownsKey(tt.entry, fp); got != tt.want {
+			t.Errorf("case %d: ownsKey = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestAccountPermissionsRoundTrip(t *testing.T) {
+	account := &Account{Name: "alice", Groups: []string{"ops"}, Roles: []string{"admin", "deploy"}}
+	got := accountFromPermissions(withAccount(nil, account))
+	if !reflect.DeepEqual(got, account) {
+		t.Errorf("got %+v, want %+v", got, account)
+	}
+	if accountFromPermissions(&ssh.Permissions{}) != nil {
+		t.Error("account found in empty permissions")
+	}
+}
@@ -0,0 +1,127 @@
+package sshserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultWebhookTimeout bounds a webhook call when WebhookAuthenticator.Client
+// is nil
+const defaultWebhookTimeout = 10 * time.Second
+
+// WebhookRequest is the JSON body a WebhookAuthenticator posts
+type WebhookRequest struct {
+	// Method is "publickey" or "password"
+	Method     string `json:"method"`
+	User       string `json:"user"`
+	RemoteAddr string `json:"remote_addr"`
+	// Fingerprint and PublicKey (in authorized_keys format) are set for
+	// publickey authentication
+	Fingerprint string `json:"fingerprint,omitempty"`
+	PublicKey   string `json:"public_key,omitempty"`
+	// Password is set for password authentication
+	Password string `json:"password,omitempty"`
+}
+
+// WebhookResponse is the JSON answer expected from the webhook
+type WebhookResponse struct {
+	Allow      bool              `json:"allow"`
+	Groups     []string          `json:"groups,omitempty"`
+	Roles      []string          `json:"roles,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// WebhookAuthenticator is an Authenticator asking an HTTP endpoint whether a
+// login is allowed. It posts a WebhookRequest and expects a 2xx response
+// holding a WebhookResponse; other statuses are treated as backend errors.
+// Keyboard-interactive authentication is not supported.
+type WebhookAuthenticator struct {
+	// URL is the endpoint requests are posted to
+	URL string
+	// Header is added to every request, e.g. for an Authorization token
+	Header http.Header
+	// Client sends the requests; nil uses a client with a 10 second timeout
+	Client *http.Client
+	// Methods lists the methods sent to the webhook, "publickey" and
+	// "password"; nil means both
+	Methods []string
+}
+
+// PublicKey implements Authenticator
+func (w *WebhookAuthenticator) PublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*AuthResult, error) {
+	return w.call("publickey", WebhookRequest{
+		User:        conn.User(),
+		RemoteAddr:  conn.RemoteAddr().String(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		PublicKey:   string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(key))),
+	})
+}
+
+// Password implements Authenticator
+func (w *WebhookAuthenticator) Password(conn ssh.ConnMetadata, password []byte) (*AuthResult, error) {
+	return w.call("password", WebhookRequest{
+		User:       conn.User(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		Password:   string(password),
+	})
+}
+
+// KeyboardInteractive implements Authenticator; it is not supported
+func (w *WebhookAuthenticator) KeyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*AuthResult, error) {
+	return nil, ErrUnsupportedMethod
+}
+
+// call posts req for method and decodes the answer
+func (w *WebhookAuthenticator) call(method string, req WebhookRequest) (*AuthResult, error) {
+	if w.Methods != nil && !containsString(w.Methods, method) {
+		return nil, ErrUnsupportedMethod
+	}
+	req.Method = method
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook request: %v", err)
+	}
+	for name, values := range w.Header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	var answer WebhookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid webhook response: %v", err)
+	}
+	if !answer.Allow {
+		return nil, ErrAccessDenied
+	}
+
+	return &AuthResult{
+		Groups:     answer.Groups,
+		Roles:      answer.Roles,
+		Attributes: answer.Attributes,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package sshserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	sshserver "repo.nusatek.id/sugeng/gosh"
+	"repo.nusatek.id/sugeng/gosh/authtest"
+)
+
+func TestWebhookAllow(t *testing.T) {
+	var got sshserver.WebhookRequest
+	server := authtest.NewWebhookServer(func(req sshserver.WebhookRequest) sshserver.WebhookResponse {
+		got = req
+		return sshserver.WebhookResponse{
+			Allow:      true,
+			Groups:     []string{"ops"},
+			Roles:      []string{"admin"},
+			Attributes: map[string]string{"team": "infra"},
+		}
+	})
+	defer server.Close()
+
+	auth := &sshserver.WebhookAuthenticator{URL: server.URL}
+	result, err := auth.Password(sshserver.NewTestConn("alice"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &sshserver.AuthResult{
+		Groups:     []string{"ops"},
+		Roles:      []string{"admin"},
+		Attributes: map[string]string{"team": "infra"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+	if got.Method != "password" || got.User != "alice" || got.Password != "secret" || got.RemoteAddr != "192.0.2.1:50000" {
+		t.Errorf("webhook received %+v", got)
+	}
+}
+
+func TestWebhookDeny(t *testing.T) {
+	server := authtest.NewWebhookServer(func(req sshserver.WebhookRequest) sshserver.WebhookResponse {
+		return sshserver.WebhookResponse{Allow: false, Roles: []string{"admin"}}
+	})
+	defer server.Close()
+
+	auth := &sshserver.WebhookAuthenticator{URL: server.URL}
+	result, err := auth.Password(sshserver.NewTestConn("alice"), []byte("secret"))
+	if err != sshserver.ErrAccessDenied {
+		t.Errorf("got %v, want ErrAccessDenied", err)
+	}
+	if result != nil {
+		t.Errorf("denied login returned %+v", result)
+	}
+}
+
+func TestWebhookErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A body that would allow the login must not be trusted
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"allow":true}`))
+	}))
+	defer server.Close()
+
+	auth := &sshserver.WebhookAuthenticator{URL: server.URL}
+	_, err := auth.Password(sshserver.NewTestConn("alice"), []byte("secret"))
+	if err == nil || err == sshserver.ErrAccessDenied {
+		t.Errorf("got %v, want a backend error", err)
+	}
+}
+
+func TestWebhookHeader(t *testing.T) {
+	var token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("Authorization")
+		w.Write([]byte(`{"allow":true}`))
+	}))
+	defer server.Close()
+
+	auth := &sshserver.WebhookAuthenticator{
+		URL:    server.URL,
+		Header: http.Header{"Authorization": {"Bearer t0ken"}},
+	}
+	if _, err := auth.Password(sshserver.NewTestConn("alice"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if token != "Bearer t0ken" {
+		t.Errorf("got Authorization %q", token)
+	}
+}
+
+func TestWebhookMethods(t *testing.T) {
+	server := authtest.NewWebhookServer(func(req sshserver.WebhookRequest) sshserver.WebhookResponse {
+		return sshserver.WebhookResponse{Allow: true}
+	})
+	defer server.Close()
+
+	auth := &sshserver.WebhookAuthenticator{URL: server.URL, Methods: []string{"publickey"}}
+	if _, err := auth.Password(sshserver.NewTestConn("alice"), []byte("secret")); err != sshserver.ErrUnsupportedMethod {
+		t.Errorf("got %v, want ErrUnsupportedMethod", err)
+	}
+	if server.Requests() != 0 {
+		t.Errorf("excluded method reached the webhook")
+	}
+}